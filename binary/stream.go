@@ -3,8 +3,10 @@ package binary
 import (
 	"github.com/beito123/binary"
 	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
 	"strconv"
-	"strings"
 	"github.com/beito123/raklib"
 )
 
@@ -37,7 +39,7 @@ func (bs *RaknetStream) PutString(value string) error {
 
 // HexString gets hex string from Buffer (for Magic)
 func (bs *RaknetStream) HexString(n int, value *string) {
-	*value = hex.EncodeToString(bs.Buffer.Bytes())
+	*value = hex.EncodeToString(bs.Get(n))
 }
 
 // PutHexString puts hex string to Buffer
@@ -49,88 +51,194 @@ func (bs *RaknetStream) PutHexString(value string) error {
 	return bs.Put(bytes)
 }
 
+const (
+	// AddressFamilyIPv6 is AF_INET6 of sockaddr_in6 on Windows, which RakNet sends
+	AddressFamilyIPv6 = 23
+)
+
 // Address sets address got from Buffer to addr and port
-// address(version byte, address byte x4, port ushort)
+// address(version byte, address byte x4, port ushort) or
+// address(version byte, sockaddr_in6(family lshort, port ushort, flowinfo int, address byte x16, scope id int))
 func (bs *RaknetStream) Address(addr *string, port *uint16) error {
+	ip, p, err := bs.address()
+	if err != nil {
+		return err
+	}
+
+	*addr = ip.String()
+	*port = p
+
+	return nil
+}
+
+func (bs *RaknetStream) address() (netip.Addr, uint16, error) {
 	var version byte
 	err := bs.Byte(&version)
 	if err != nil {
-		return err
+		return netip.Addr{}, 0, err
 	}
 
-	var address string
+	var port uint16
 
-	if version == 4 {
-		var bytes byte
-		for i := 0; i < 4; i++ {
-			err = bs.Byte(&bytes)
-			if err != nil {
-				return err
-			}
+	switch version {
+	case 4:
+		b := bs.Get(4)
+		if len(b) < 4 {
+			return netip.Addr{}, 0, errors.New("the address is truncated")
+		}
 
-			address = address + strconv.Itoa(int(^bytes&0xff))
-			if i < 3 {
-				address = address + "."
-			}
+		var ip [4]byte
+		for i := range b {
+			ip[i] = ^b[i] & 0xff
 		}
-		addr = &address
 
-		err = bs.Short(port)
+		err = bs.Short(&port)
 		if err != nil {
-			return err
+			return netip.Addr{}, 0, err
+		}
+
+		return netip.AddrFrom4(ip), port, nil
+	case 6:
+		var family uint16
+		err = bs.LShort(&family)
+		if err != nil {
+			return netip.Addr{}, 0, err
+		}
+
+		err = bs.Short(&port)
+		if err != nil {
+			return netip.Addr{}, 0, err
+		}
+
+		var flowInfo int32
+		err = bs.Int(&flowInfo)
+		if err != nil {
+			return netip.Addr{}, 0, err
+		}
+
+		b := bs.Get(16)
+		if len(b) < 16 {
+			return netip.Addr{}, 0, errors.New("the address is truncated")
 		}
-	} else {
-		// IPv6
+
+		var ip [16]byte
+		copy(ip[:], b)
+
+		var scopeID int32
+		err = bs.Int(&scopeID)
+		if err != nil {
+			return netip.Addr{}, 0, err
+		}
+
+		return netip.AddrFrom16(ip).WithZone(zone(uint32(scopeID))), port, nil
 	}
 
-	return nil
+	return netip.Addr{}, 0, errors.New("unknown address version: " + strconv.Itoa(int(version)))
 }
 
 // PutAddress puts address to Buffer
-// address(version byte, address byte x4, port ushort)
+// address(version byte, address byte x4, port ushort) or
+// address(version byte, sockaddr_in6(family lshort, port ushort, flowinfo int, address byte x16, scope id int))
 func (bs *RaknetStream) PutAddress(addr string, port uint16, version byte) error {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	return bs.putAddress(ip, port, version)
+}
+
+func (bs *RaknetStream) putAddress(ip netip.Addr, port uint16, version byte) error {
 	err := bs.PutByte(version)
 	if err != nil {
 		return err
 	}
 
-	if version == 4 {
-		for _, str := range strings.Split(addr, ".") {
-			i, _ := strconv.Atoi(str)
-			err = bs.PutByte(^byte(i) & 0xff)
+	switch version {
+	case 4:
+		if !ip.Unmap().Is4() {
+			return errors.New("the address isn't ipv4: " + ip.String())
+		}
+
+		for _, b := range ip.Unmap().As4() {
+			err = bs.PutByte(^b & 0xff)
 			if err != nil {
 				return err
 			}
 		}
+
+		return bs.PutShort(port)
+	case 6:
+		err = bs.PutLShort(AddressFamilyIPv6)
+		if err != nil {
+			return err
+		}
+
 		err = bs.PutShort(port)
 		if err != nil {
 			return err
 		}
-	} else {
-		// ipv6
+
+		err = bs.PutInt(0) // flowinfo
+		if err != nil {
+			return err
+		}
+
+		b := ip.As16()
+		err = bs.Put(b[:])
+		if err != nil {
+			return err
+		}
+
+		return bs.PutInt(int32(scopeID(ip.Zone())))
 	}
 
-	return nil
+	return errors.New("unknown address version: " + strconv.Itoa(int(version)))
 }
 
 // AddressSystemAddress sets address got from Buffer to SystemAddress
 func (bs *RaknetStream) AddressSystemAddress(addr *raklib.SystemAddress) error {
-	var add string
-	var port uint16
-
-	err := bs.Address(&add, &port)
+	ip, port, err := bs.address()
 	if err != nil {
 		return err
 	}
 
-	naddr := raklib.NewSystemAddress(add, port)
-
-	*addr = *naddr
+	*addr = raklib.NewSystemAddress(ip, port)
 
 	return nil
 }
 
-// PutAddressSystemAddress puts address from UDPAddr to Buffer
+// PutAddressSystemAddress puts address from SystemAddress to Buffer
 func (bs *RaknetStream) PutAddressSystemAddress(addr raklib.SystemAddress) error {
-	return bs.PutAddress(addr.IP.String(), addr.Port, byte(addr.Version()))
+	return bs.putAddress(addr.Addr(), addr.Port(), byte(addr.Version()))
+}
+
+// scopeID returns the scope id of sockaddr_in6 from the zone of an IPv6 address
+func scopeID(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+
+	if id, err := strconv.ParseUint(zone, 10, 32); err == nil {
+		return uint32(id)
+	}
+
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+
+	return 0
+}
+
+// zone returns the zone of an IPv6 address from the scope id of sockaddr_in6
+func zone(scopeID uint32) string {
+	if scopeID == 0 {
+		return ""
+	}
+
+	if ifi, err := net.InterfaceByIndex(int(scopeID)); err == nil {
+		return ifi.Name
+	}
+
+	return strconv.FormatUint(uint64(scopeID), 10)
 }
@@ -0,0 +1,122 @@
+package binary
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/beito123/raklib"
+)
+
+func TestAddressSystemAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		want []byte
+	}{
+		{
+			name: "ipv4",
+			addr: "192.168.1.2:19132",
+			want: []byte{0x04, 0x3f, 0x57, 0xfe, 0xfd, 0x4a, 0xbc},
+		},
+		{
+			name: "ipv4-mapped ipv6",
+			addr: "[::ffff:10.0.0.1]:80",
+			want: []byte{0x04, 0xf5, 0xff, 0xff, 0xfe, 0x00, 0x50},
+		},
+		{
+			name: "ipv6",
+			addr: "[::1]:19132",
+			want: []byte{0x06, 0x17, 0x00, 0x4a, 0xbc, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "ipv6 with scope id",
+			addr: "[fe80::1%2147483632]:5",
+			want: []byte{0x06, 0x17, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00,
+				0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x7f, 0xff, 0xff, 0xf0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := raklib.ParseSystemAddress(test.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bs := &RaknetStream{}
+			bs.Buffer = &bytes.Buffer{}
+
+			err = bs.PutAddressSystemAddress(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(bs.Bytes(), test.want) {
+				t.Errorf("encoded % x, want % x", bs.Bytes(), test.want)
+			}
+
+			var decoded raklib.SystemAddress
+			err = bs.AddressSystemAddress(&decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded != addr {
+				t.Errorf("decoded %s, want %s", decoded, addr)
+			}
+
+			if bs.Buffer.Len() != 0 {
+				t.Errorf("%d bytes are left", bs.Buffer.Len())
+			}
+		})
+	}
+}
+
+func TestAddressErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "unknown version",
+			b:    []byte{0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "truncated ipv4",
+			b:    []byte{0x04, 0x3f, 0x57},
+		},
+		{
+			name: "truncated ipv6",
+			b:    []byte{0x06, 0x17, 0x00, 0x4a, 0xbc, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := &RaknetStream{}
+			bs.Buffer = bytes.NewBuffer(test.b)
+
+			var addr raklib.SystemAddress
+			err := bs.AddressSystemAddress(&addr)
+			if err == nil {
+				t.Errorf("decoded %s without error", addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package client
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+	"github.com/beito123/raklib/server"
+	"github.com/beito123/raklib/session"
+)
+
+const (
+	// RequestInterval is the time until an offline handshake request is sent again
+	RequestInterval = 500 * time.Millisecond
+
+	// MaxRequests is the number of times an offline handshake request is sent
+	MaxRequests = 5
+
+	// MaxProbes is the number of times each mtu size is probed
+	MaxProbes = 2
+)
+
+// DefaultMTUs is the mtu sizes probed in the offline handshake by default
+var DefaultMTUs = []uint16{1492, 1200, 576}
+
+var (
+	// ErrNoReply is returned when the server doesn't reply to the offline handshake
+	ErrNoReply = errors.New("the server didn't reply")
+
+	// ErrClosed is returned when the connection is closed
+	ErrClosed = errors.New("the connection is closed")
+
+	// ErrInvalidMTU is returned when the server replies with an mtu size out of the range
+	ErrInvalidMTU = errors.New("the server replied with an invalid mtu")
+)
+
+// Dialer connects to Raknet servers
+type Dialer struct {
+	// GUID is the unique id of the client
+	GUID int64
+
+	// MTUs is the mtu sizes probed in the offline handshake, largest first
+	// The next size is probed if the server doesn't reply, because the datagram may be too large
+	MTUs []uint16
+
+	// SessionConfig is the configuration of the session
+	SessionConfig session.Config
+}
+
+// NewDialer returns a new Dialer
+func NewDialer() *Dialer {
+	return &Dialer{
+		GUID:          rand.Int63(),
+		MTUs:          append([]uint16(nil), DefaultMTUs...),
+		SessionConfig: session.DefaultConfig(),
+	}
+}
+
+// Dial connects to the server at address with a new Dialer
+func Dial(ctx context.Context, address string) (*Conn, error) {
+	return NewDialer().Dial(ctx, address)
+}
+
+// Dial connects to the server at address
+// It runs the offline and the connected handshake and returns after the server accepts the connection
+func (dialer *Dialer) Dial(ctx context.Context, address string) (*Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:    conn,
+		addr:    addr,
+		packets: make(chan []byte, 256),
+		opened:  make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+
+	err = c.handshake(ctx, dialer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	go c.tick(dialer.SessionConfig.WithDefaults().FlushInterval)
+
+	err = c.Session.Connect(dialer.GUID)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	select {
+	case <-c.opened:
+		return c, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Conn is a connection to a Raknet server
+// Messages are sent with the methods of the session and received with ReadPacket
+type Conn struct {
+	*session.Session
+
+	conn    *net.UDPConn
+	addr    *net.UDPAddr
+	packets chan []byte
+	opened  chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// ReadPacket returns the next received message
+// It returns ErrClosed after the connection is closed and all messages are read
+func (c *Conn) ReadPacket() ([]byte, error) {
+	select {
+	case b := <-c.packets:
+		return b, nil
+	case <-c.closed:
+		select {
+		case b := <-c.packets:
+			return b, nil
+		default:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Close sends a disconnection notification and closes the connection
+func (c *Conn) Close() error {
+	if c.Session != nil {
+		c.Session.Close(session.ReasonClientClosed)
+	}
+
+	c.shutdown()
+
+	return nil
+}
+
+// Done returns a channel which is closed when the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) shutdown() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// handshake runs the offline handshake and creates the session
+func (c *Conn) handshake(ctx context.Context, dialer *Dialer) error {
+	reply1, err := c.discoverMTU(ctx, dialer.MTUs)
+	if err != nil {
+		return err
+	}
+
+	if reply1.Magic != raklib.Magic {
+		return errors.New("the reply has invalid magic")
+	}
+
+	if reply1.Security {
+		return errors.New("the server requires security, which isn't supported")
+	}
+
+	req2 := &protocol.OpenConnectionRequest2Packet{
+		ServerAddress: raklib.NewSystemAddressUDP(c.addr),
+		MTU:           reply1.MTU,
+		ClientUUID:    dialer.GUID,
+	}
+
+	err = req2.Encode()
+	if err != nil {
+		return err
+	}
+
+	b, err := request(ctx, c.conn, c.addr, req2.Bytes(), protocol.IDOpenConnectionReply2, MaxRequests)
+	if err != nil {
+		return err
+	}
+
+	reply2 := &protocol.OpenConnectionReply2Packet{}
+	reply2.Buffer = bytes.NewBuffer(b)
+
+	err = reply2.Decode()
+	if err != nil {
+		return err
+	}
+
+	if reply2.Magic != raklib.Magic {
+		return errors.New("the reply has invalid magic")
+	}
+
+	if reply2.MTU < server.MinMTU || reply2.MTU > reply1.MTU {
+		return ErrInvalidMTU
+	}
+
+	c.Session = session.NewSession(c.conn, c.addr, raklib.NewSystemAddressUDP(c.addr), reply2.MTU,
+		reply2.ServerUUID, &handler{conn: c}, dialer.SessionConfig)
+
+	return nil
+}
+
+// discoverMTU sends open connection requests padded to mtus until the server replies
+// It steps down to the next mtu size when the server doesn't reply
+func (c *Conn) discoverMTU(ctx context.Context, mtus []uint16) (*protocol.OpenConnectionReply1Packet, error) {
+	for _, mtu := range mtus {
+		req := &protocol.OpenConnectionRequest1Packet{
+			Protocol: raklib.ProtocolVersion,
+			MTU:      mtu,
+		}
+
+		err := req.Encode()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := request(ctx, c.conn, c.addr, req.Bytes(), protocol.IDOpenConnectionReply1, MaxProbes)
+		if err == ErrNoReply {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		reply := &protocol.OpenConnectionReply1Packet{}
+		reply.Buffer = bytes.NewBuffer(b)
+
+		err = reply.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		// the server can't use a larger mtu than the probed one
+		if reply.MTU < server.MinMTU || reply.MTU > mtu {
+			return nil, ErrInvalidMTU
+		}
+
+		return reply, nil
+	}
+
+	return nil, ErrNoReply
+}
+
+// request sends b to addr until it replies with a packet of id
+// b is sent up to attempts times
+func request(ctx context.Context, conn *net.UDPConn, addr *net.UDPAddr, b []byte, id byte, attempts int) ([]byte, error) {
+	buf := make([]byte, 2048)
+
+	for i := 0; i < attempts; i++ {
+		_, err := conn.WriteToUDP(b, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(RequestInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+
+		err = conn.SetReadDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+
+				return nil, err
+			}
+
+			if n == 0 || buf[0] != id || !isAddress(from, addr) {
+				continue
+			}
+
+			conn.SetReadDeadline(time.Time{})
+
+			reply := make([]byte, n)
+			copy(reply, buf[:n])
+
+			return reply, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// the read deadline may pass slightly before ctx is done
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	return nil, ErrNoReply
+}
+
+// read reads datagrams from the server until the connection is closed
+func (c *Conn) read() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			c.shutdown()
+			return
+		}
+
+		// Connected datagrams all have the valid flag
+		if n == 0 || buf[0]&protocol.FlagValid == 0 || !isAddress(addr, c.addr) {
+			continue
+		}
+
+		b := make([]byte, n)
+		copy(b, buf[:n])
+
+		c.Session.HandleDatagram(b)
+	}
+}
+
+// tick updates the session until the connection is closed
+func (c *Conn) tick(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			if c.Session.IsClosed() {
+				c.shutdown()
+				return
+			}
+
+			if c.Session.IsTimedOut(now) {
+				c.Session.Close(session.ReasonTimeout)
+				c.shutdown()
+				return
+			}
+
+			c.Session.Update(now)
+		}
+	}
+}
+
+// handler passes events of the session to the connection
+type handler struct {
+	conn *Conn
+}
+
+func (h *handler) OnOpen(*session.Session) {
+	close(h.conn.opened)
+}
+
+func (h *handler) OnPacket(_ *session.Session, payload []byte) {
+	select {
+	case h.conn.packets <- payload:
+	case <-h.conn.closed:
+	}
+}
+
+func (h *handler) OnClose(*session.Session, string) {
+	h.conn.shutdown()
+}
+
+func isAddress(addr *net.UDPAddr, sub *net.UDPAddr) bool {
+	return addr.IP.Equal(sub.IP) && addr.Port == sub.Port
+}
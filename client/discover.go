@@ -0,0 +1,207 @@
+package client
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+)
+
+// Discover finds servers on the local network
+// It broadcasts unconnected pings to port and sends the pongs received within window to the channel.
+// Each server is sent once, and the channel is closed when window passes or ctx is done.
+// If openConnections is true, only servers which have free slots reply
+func Discover(ctx context.Context, port int, window time.Duration, openConnections bool) (<-chan *Pong, error) {
+	// udp sockets can send broadcast datagrams by default
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &discovery{
+		conn: conn,
+		addr: &net.UDPAddr{
+			IP:   net.IPv4bcast,
+			Port: port,
+		},
+		guid:            rand.Int63(),
+		openConnections: openConnections,
+		sendTimes:       make(map[int64]time.Time),
+		pongs:           make(chan *Pong),
+	}
+
+	err = d.ping(time.Now())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go d.run(ctx, time.Now().Add(window))
+
+	return d.pongs, nil
+}
+
+// discovery collects pongs to broadcast pings
+type discovery struct {
+	conn            *net.UDPConn
+	addr            *net.UDPAddr
+	guid            int64
+	openConnections bool
+	sendTimes       map[int64]time.Time
+	nextSend        time.Time
+	pongs           chan *Pong
+}
+
+// ping broadcasts an unconnected ping
+func (d *discovery) ping(now time.Time) error {
+	b, err := encodePing(now, d.guid, d.openConnections)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.WriteToUDP(b, d.addr)
+	if err != nil {
+		return err
+	}
+
+	d.sendTimes[now.UnixNano()/int64(time.Millisecond)] = now
+	d.nextSend = now.Add(RequestInterval)
+
+	return nil
+}
+
+// run reads pongs until end or ctx is done
+func (d *discovery) run(ctx context.Context, end time.Time) {
+	defer close(d.pongs)
+	defer d.conn.Close()
+
+	// unblock reading when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	// stop waiting for the receiver of pongs at end
+	timer := time.NewTimer(time.Until(end))
+	defer timer.Stop()
+
+	found := make(map[string]bool)
+
+	buf := make([]byte, 2048)
+	for {
+		now := time.Now()
+		if !now.Before(end) || ctx.Err() != nil {
+			return
+		}
+
+		// pings are sent again in case they're lost
+		if !now.Before(d.nextSend) {
+			err := d.ping(now)
+			if err != nil {
+				return
+			}
+		}
+
+		deadline := d.nextSend
+		if end.Before(deadline) {
+			deadline = end
+		}
+
+		d.conn.SetReadDeadline(deadline)
+
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				continue
+			}
+
+			return
+		}
+
+		if n == 0 || buf[0] != protocol.IDUnconnectedPong || found[from.String()] {
+			continue
+		}
+
+		pong := &protocol.UnconnectedPongPacket{}
+		pong.Buffer = bytes.NewBuffer(append([]byte(nil), buf[:n]...))
+
+		err = pong.Decode()
+		if err != nil || pong.Magic != raklib.Magic {
+			continue
+		}
+
+		sendTime, ok := d.sendTimes[pong.PingID]
+		if !ok {
+			continue
+		}
+
+		found[from.String()] = true
+
+		select {
+		case d.pongs <- &Pong{
+			Addr:    from,
+			GUID:    pong.ServerID,
+			RTT:     time.Since(sendTime),
+			Payload: pong.ServerName,
+		}:
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// encodePing returns an unconnected ping sent at now
+func encodePing(now time.Time, guid int64, openConnections bool) ([]byte, error) {
+	t := now.UnixNano() / int64(time.Millisecond)
+
+	if openConnections {
+		pk := &protocol.UnconnectedPingOpenConnections{
+			Time:       t,
+			Magic:      raklib.Magic,
+			ClientUUID: guid,
+		}
+
+		err := pk.Encode()
+		if err != nil {
+			return nil, err
+		}
+
+		return pk.Bytes(), nil
+	}
+
+	pk := &protocol.UnconnectedPingPacket{
+		Time:       t,
+		Magic:      raklib.Magic,
+		ClientUUID: guid,
+	}
+
+	err := pk.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return pk.Bytes(), nil
+}
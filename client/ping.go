@@ -0,0 +1,102 @@
+package client
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+)
+
+// Pong is the reply to an unconnected ping
+type Pong struct {
+	// Addr is the address of the server
+	Addr *net.UDPAddr
+
+	// GUID is the unique id of the server
+	GUID int64
+
+	// RTT is the round trip time of the ping
+	RTT time.Duration
+
+	// Payload is the server status sent in the pong, such as the server name
+	Payload string
+}
+
+// Ping sends unconnected pings to the server at address until it replies
+func Ping(ctx context.Context, address string) (*Pong, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	guid := rand.Int63()
+	sendTimes := make(map[int64]time.Time)
+
+	for i := 0; i < MaxRequests; i++ {
+		now := time.Now()
+
+		ping, err := encodePing(now, guid, false)
+		if err != nil {
+			return nil, err
+		}
+
+		sendTimes[now.UnixNano()/int64(time.Millisecond)] = now
+
+		b, err := request(ctx, conn, addr, ping, protocol.IDUnconnectedPong, 1)
+		if err == ErrNoReply {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		pong := &protocol.UnconnectedPongPacket{}
+		pong.Buffer = bytes.NewBuffer(b)
+
+		err = pong.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		if pong.Magic != raklib.Magic {
+			return nil, errors.New("the pong has invalid magic")
+		}
+
+		// the pong may be the reply to an earlier ping
+		sendTime, ok := sendTimes[pong.PingID]
+		if !ok {
+			sendTime = now
+		}
+
+		return &Pong{
+			Addr:    addr,
+			GUID:    pong.ServerID,
+			RTT:     time.Since(sendTime),
+			Payload: pong.ServerName,
+		}, nil
+	}
+
+	return nil, ErrNoReply
+}
@@ -1 +1,194 @@
 package protocol
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/beito123/binary"
+	"github.com/beito123/raklib"
+)
+
+const (
+	// MaxAcknowledgeRecords is the maximum number of sequence numbers decoded from a packet
+	MaxAcknowledgeRecords = 4096
+
+	triadMask = 1<<24 - 1
+)
+
+// AcknowledgePacket is the base of ACK and NACK packets
+//
+// Format: record count(short), records
+// Record: single(bool), start(ltriad), end(ltriad, only if not single)
+type AcknowledgePacket struct {
+	BasePacket
+
+	Packets []binary.Triad // sequence numbers of datagrams
+}
+
+func (ack *AcknowledgePacket) encode(pk raklib.Packet) error {
+	err := ack.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	records := ack.records()
+
+	err = ack.PutShort(uint16(len(records)))
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		single := rec[0] == rec[1]
+
+		err = ack.PutBool(single)
+		if err != nil {
+			return err
+		}
+
+		err = ack.PutLTriad(rec[0])
+		if err != nil {
+			return err
+		}
+
+		if !single {
+			err = ack.PutLTriad(rec[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// records collapses runs of consecutive sequence numbers into ranges
+func (ack *AcknowledgePacket) records() [][2]binary.Triad {
+	if len(ack.Packets) == 0 {
+		return nil
+	}
+
+	seqs := make([]binary.Triad, len(ack.Packets))
+	copy(seqs, ack.Packets)
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	records := [][2]binary.Triad{{seqs[0], seqs[0]}}
+	for _, seq := range seqs[1:] {
+		last := &records[len(records)-1]
+		if seq == last[1] { // duplicated
+			continue
+		}
+
+		if seq == last[1]+1 {
+			last[1] = seq
+			continue
+		}
+
+		records = append(records, [2]binary.Triad{seq, seq})
+	}
+
+	return records
+}
+
+func (ack *AcknowledgePacket) decode(pk raklib.Packet) error {
+	err := ack.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	var count uint16
+	err = ack.Short(&count)
+	if err != nil {
+		return err
+	}
+
+	ack.Packets = nil
+
+	for i := 0; i < int(count); i++ {
+		var single bool
+		err = ack.Bool(&single)
+		if err != nil {
+			return err
+		}
+
+		var start binary.Triad
+		err = ack.LTriad(&start)
+		if err != nil {
+			return err
+		}
+
+		end := start
+		if !single {
+			err = ack.LTriad(&end)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the range may wrap around at 24 bits
+		n := int((end-start)&triadMask) + 1
+		if len(ack.Packets)+n > MaxAcknowledgeRecords {
+			return errors.New("too many acknowledge records")
+		}
+
+		for seq := start; n > 0; n-- {
+			ack.Packets = append(ack.Packets, seq)
+			seq = (seq + 1) & triadMask
+		}
+	}
+
+	return nil
+}
+
+type ACKPacket struct {
+	AcknowledgePacket
+}
+
+func (ACKPacket) ID() byte {
+	return IDACK
+}
+
+func (ACKPacket) New() raklib.Packet {
+	return new(ACKPacket)
+}
+
+func (pk *ACKPacket) Encode() error {
+	return pk.AcknowledgePacket.encode(pk)
+}
+
+func (pk *ACKPacket) Decode() error {
+	return pk.AcknowledgePacket.decode(pk)
+}
+
+type NACKPacket struct {
+	AcknowledgePacket
+}
+
+func (NACKPacket) ID() byte {
+	return IDNACK
+}
+
+func (NACKPacket) New() raklib.Packet {
+	return new(NACKPacket)
+}
+
+func (pk *NACKPacket) Encode() error {
+	return pk.AcknowledgePacket.encode(pk)
+}
+
+func (pk *NACKPacket) Decode() error {
+	return pk.AcknowledgePacket.decode(pk)
+}
@@ -0,0 +1,121 @@
+package protocol
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/beito123/binary"
+)
+
+func TestAcknowledgePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []binary.Triad
+		want    []byte         // encoded records without the id
+		decoded []binary.Triad // nil if the same as packets
+	}{
+		{
+			name:    "single",
+			packets: []binary.Triad{0x010203},
+			want:    []byte{0x00, 0x01, 0x01, 0x03, 0x02, 0x01},
+		},
+		{
+			name:    "range",
+			packets: []binary.Triad{4, 5, 6},
+			want:    []byte{0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x06, 0x00, 0x00},
+		},
+		{
+			name:    "unsorted and duplicated",
+			packets: []binary.Triad{9, 1, 2, 9},
+			want: []byte{0x00, 0x02, 0x00, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00,
+				0x01, 0x09, 0x00, 0x00},
+			decoded: []binary.Triad{1, 2, 9},
+		},
+		{
+			// RakNet rejects a range whose end is before the start,
+			// so a run across the wraparound is sent as two ranges
+			name:    "wraparound",
+			packets: []binary.Triad{0xfffffe, 0xffffff, 0, 1},
+			want: []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+				0x00, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff},
+			decoded: []binary.Triad{0, 1, 0xfffffe, 0xffffff},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pk := &ACKPacket{}
+			pk.Packets = test.packets
+
+			err := pk.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b := pk.Bytes()
+			if b[0] != IDACK {
+				t.Errorf("id = %x", b[0])
+			}
+
+			if !bytes.Equal(b[1:], test.want) {
+				t.Errorf("records = % x, want % x", b[1:], test.want)
+			}
+
+			decoded, err := DecodeDatagram(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := test.decoded
+			if want == nil {
+				want = test.packets
+			}
+
+			got := decoded.(*ACKPacket).Packets
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestAcknowledgePacketDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "truncated count",
+			b:    []byte{IDNACK, 0x00},
+		},
+		{
+			name: "truncated record",
+			b:    []byte{IDNACK, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00},
+		},
+		{
+			name: "too many records",
+			b:    []byte{IDNACK, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeDatagram(test.b)
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
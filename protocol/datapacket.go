@@ -12,9 +12,9 @@ package protocol
 */
 
 import (
+	"errors"
+
 	"github.com/beito123/binary"
-	"math"
-	"bytes"
 	"github.com/beito123/raklib"
 )
 
@@ -55,47 +55,52 @@ func (r Reliability) IsNeededACK() bool {
 		r == ReliableOrderedWithACKReceipt
 }
 
-func (r Reliability) ToBinary() byte {
-	var b byte
-
-	if r.IsReliable() {
-		b |= 1 << 2
+// WithoutACKReceipt returns the reliability without ACK receipt
+func (r Reliability) WithoutACKReceipt() Reliability {
+	switch r {
+	case UnreliableWithACKReceipt:
+		return Unreliable
+	case ReliableWithACKReceipt:
+		return Reliable
+	case ReliableOrderedWithACKReceipt:
+		return ReliableOrdered
 	}
 
-	if r.IsOrdered() {
-		b |= 1 << 1
-	}
-
-	if r.IsSequenced() {
-		b |= 1
-	}
+	return r
+}
 
-	return b
+// ToBinary returns the reliability as a 3 bit integer (0-7)
+func (r Reliability) ToBinary() byte {
+	return byte(r) & 0x07
 }
 
+// ReliabilityFromBinary returns the reliability from a 3 bit integer (0-7)
 func ReliabilityFromBinary(b byte) Reliability {
-	if (b & 0x04) > 0 { // Reliable
-		if (b & 0x02) > 0 { // Ordered
-			return ReliableOrdered
-		} else if (b & 0x01) > 0 { // Sequenced
-			return ReliableSequenced
-		} else {
-			return Reliable
-		}
-	} else { // Unreliable
-		if (b & 0x01) > 0 { // Sequenced
-			return UnreliableSequenced
-		}
-	}
-
-	return Unreliable
+	return Reliability(b & 0x07)
 }
 
-// EncapsulatedPacket .
+var (
+	// ErrTruncatedPacket is returned when a packet is shorter than its header says
+	ErrTruncatedPacket = errors.New("the packet is truncated")
+
+	// ErrInvalidLength is returned when a packet has an invalid body length
+	ErrInvalidLength = errors.New("the packet has an invalid length")
+)
+
+const (
+	// MaxEncapsulatedBodySize is the maximum body size of EncapsulatedPacket
+	// The length is sent in bits as a short
+	MaxEncapsulatedBodySize = 0xffff >> 3
+)
+
+// EncapsulatedPacket is a frame in a DataPacket
+//
+// Format: flags(byte), length in bits(short), reliable index(ltriad, only if reliable),
+// sequence index(ltriad, only if sequenced), order index(ltriad) and order channel(byte) (only if ordered or sequenced),
+// split count(int), split id(short), split index(int) (only if split), body
 type EncapsulatedPacket struct {
-	binary.Stream
 	Flags  byte
-	Length uint16
+	Length uint16 // length of the body in bits
 
 	ReliableIndex binary.Triad // only if reliable
 
@@ -115,79 +120,75 @@ type EncapsulatedPacket struct {
 	Reliability Reliability
 	HasSplit    bool
 
-	// NeedACK       bool //ACK
-	// IdentifierACK int
+	// Local only, these aren't sent
+
+	NeedACK       bool   // whether the sender wants an ACK receipt
+	IdentifierACK uint32 // receipt id, only if NeedACK
 }
 
-// NewEncapsulatedPacket .
-func NewEncapsulatedPacket(buffer *bytes.Buffer) *EncapsulatedPacket {
-	return &EncapsulatedPacket{
-		Stream: binary.Stream{
-			Buffer: buffer,
-		},
+// Encode encodes the packet into bs
+func (epk *EncapsulatedPacket) Encode(bs *binary.Stream) error {
+	if len(epk.Body) == 0 || len(epk.Body) > MaxEncapsulatedBodySize {
+		return ErrInvalidLength
 	}
-}
 
-// Encode encodes packet to binary
-func (epk *EncapsulatedPacket) Encode() error {
 	epk.EncodeFlags()
+	epk.Length = uint16(len(epk.Body) << 3)
 
-	err := epk.PutByte(epk.Flags)
+	err := bs.PutByte(epk.Flags)
 	if err != nil {
 		return err
 	}
 
-	err = epk.PutShort(uint16(epk.Buffer.Len() << 3))
+	err = bs.PutShort(epk.Length)
 	if err != nil {
 		return err
 	}
 
 	if epk.Reliability.IsReliable() { // Reliable
-		err = epk.PutTriad(epk.ReliableIndex)
+		err = bs.PutLTriad(epk.ReliableIndex)
 		if err != nil {
 			return err
 		}
 	}
 
-	if epk.Reliability.IsOrdered() { // Ordered
-		err = epk.PutTriad(epk.OrderIndex)
+	if epk.Reliability.IsSequenced() { // Sequenced
+		err = bs.PutLTriad(epk.SequenceIndex)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = epk.PutByte(epk.OrderChannel)
+	if epk.Reliability.IsOrdered() { // Ordered, sequenced packets have the order index too
+		err = bs.PutLTriad(epk.OrderIndex)
 		if err != nil {
 			return err
 		}
-	}
 
-	if epk.Reliability.IsSequenced() { // Sequenced
-		err = epk.PutTriad(epk.SequenceIndex)
+		err = bs.PutByte(epk.OrderChannel)
 		if err != nil {
 			return err
 		}
 	}
 
 	if epk.HasSplit {
-		err = epk.PutInt(epk.SplitCount)
+		err = bs.PutInt(epk.SplitCount)
 		if err != nil {
 			return err
 		}
 
-		err = epk.PutShort(epk.SplitID)
+		err = bs.PutShort(epk.SplitID)
 		if err != nil {
 			return err
 		}
 
-		err = epk.PutInt(epk.SplitIndex)
+		err = bs.PutInt(epk.SplitIndex)
 		if err != nil {
 			return err
 		}
 	}
 
-	epk.Put(epk.Buffer.Bytes())
-
-	return nil
+	return bs.Put(epk.Body)
 }
 
 // EncodeFlags encodes the internal variables to binary
@@ -205,79 +206,84 @@ func (epk *EncapsulatedPacket) EncodeFlags() {
 	epk.Flags = flags
 }
 
-// Decode . FromBinary
-func (epk *EncapsulatedPacket) Decode() error {
-
-	err := epk.Byte(&epk.Flags)
+// Decode decodes the packet from bs
+// It returns ErrTruncatedPacket if bs is shorter than the packet
+func (epk *EncapsulatedPacket) Decode(bs *binary.Stream) error {
+	err := bs.Byte(&epk.Flags)
 	if err != nil {
-		return err
+		return ErrTruncatedPacket
 	}
 
-	err = epk.Short(&epk.Length)
+	err = bs.Short(&epk.Length)
 	if err != nil {
-		return err
+		return ErrTruncatedPacket
+	}
+
+	if epk.Length == 0 {
+		return ErrInvalidLength
 	}
 
 	// ref: http://www.jenkinssoftware.com/raknet/manual/reliabilitytypes.html
 
 	// xxx y zzzz
-	// xxx: Reliable, Ordered, Sequenced. yyy: Has Split. zzzz: empty
+	// xxx: Reliability (0-7). y: Has Split. zzzz: empty
 	epk.Reliability = ReliabilityFromBinary(epk.Flags >> 5)
 	epk.HasSplit = (epk.Flags & 0x10) > 0
 
 	if epk.Reliability.IsReliable() { // Reliable
-		err = epk.Triad(&epk.ReliableIndex)
+		err = bs.LTriad(&epk.ReliableIndex)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
 	}
 
-	if epk.Reliability.IsOrdered() { // Ordered
-		err = epk.Triad(&epk.OrderIndex)
+	if epk.Reliability.IsSequenced() { // Sequenced
+		err = bs.LTriad(&epk.SequenceIndex)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
+	}
 
-		err = epk.Byte(&epk.OrderChannel)
+	if epk.Reliability.IsOrdered() { // Ordered, sequenced packets have the order index too
+		err = bs.LTriad(&epk.OrderIndex)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
-	}
 
-	if epk.Reliability.IsSequenced() { // Sequenced
-		err = epk.Triad(&epk.SequenceIndex)
+		err = bs.Byte(&epk.OrderChannel)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
 	}
 
 	if epk.HasSplit {
-		err = epk.Int(&epk.SplitCount)
+		err = bs.Int(&epk.SplitCount)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
 
-		err = epk.Short(&epk.SplitID)
+		err = bs.Short(&epk.SplitID)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
 
-		err = epk.Int(&epk.SplitIndex)
+		err = bs.Int(&epk.SplitIndex)
 		if err != nil {
-			return err
+			return ErrTruncatedPacket
 		}
 	}
 
-	bodyLen := int(math.Ceil(float64(epk.Length / 8)))
-	epk.Body = epk.Get(bodyLen)
+	bodyLen := (int(epk.Length) + 7) / 8 // bits to bytes, rounded up
+	if bs.Buffer.Len() < bodyLen {
+		return ErrTruncatedPacket
+	}
 
-	return nil
-}
+	epk.Body = bs.Get(bodyLen)
 
-func (epk *EncapsulatedPacket) GetBuffer() []byte {
-	return epk.Bytes()
+	return nil
 }
 
+// Len returns the encoded size of the packet
 func (epk *EncapsulatedPacket) Len() int {
 	ln := 3 // flags(1byte), short(2byte)
 	ln += len(epk.Body)
@@ -304,232 +310,121 @@ func (epk *EncapsulatedPacket) Len() int {
 
 // DataPacket
 
+const (
+	// DataPacketHeaderSize is the size of the header of DataPacket
+	// flags(1byte), index(ltriad)
+	DataPacketHeaderSize = 4
+)
+
+// DataPacket is a datagram which has encapsulated packets
+// The id is the header flags, which always have FlagValid
 type DataPacket struct {
 	BasePacket
+	Flags   byte // header flags
 	Index   binary.Triad
 	Packets []*EncapsulatedPacket
 }
 
-func (DataPacket) ID() byte {
-	return 0xff
+func (bp *DataPacket) ID() byte {
+	return FlagValid | bp.Flags
 }
 
 func (DataPacket) New() raklib.Packet {
 	return new(DataPacket)
 }
 
-func (bp *DataPacket) Encode() error {
-	bp.BasePacket.Encode(bp)
+// HasFlag returns whether the header has flag
+func (bp *DataPacket) HasFlag(flag byte) bool {
+	return bp.Flags&flag != 0
+}
 
-	bp.PutTriad(bp.Index)
-	for _, pk := range bp.Packets {
-		pk.Encode()
-		bp.Put(pk.GetBuffer())
+func (bp *DataPacket) Encode() error {
+	if bp.HasFlag(FlagACK | FlagNAK) {
+		return errors.New("data packets can't have ACK or NAK flags")
 	}
-	return nil
-}
 
-func (bp *DataPacket) Decode() error {
-	bp.BasePacket.Decode(bp)
+	err := bp.BasePacket.Encode(bp)
+	if err != nil {
+		return err
+	}
 
-	bp.Triad(&bp.Index)
+	err = bp.PutLTriad(bp.Index)
+	if err != nil {
+		return err
+	}
 
-	for bp.Buffer.Len() > 0 {
-		epk := NewEncapsulatedPacket(bp.Buffer)
-		bp.Packets = append(bp.Packets, epk)
+	for _, pk := range bp.Packets {
+		err = pk.Encode(&bp.Stream)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-type DataPacket0 struct {
-	DataPacket
-}
-
-func (DataPacket0) ID() byte {
-	return IDDataPacket0
-}
-
-func (DataPacket0) New() raklib.Packet {
-	return new(DataPacket0)
-}
-
-type DataPacket1 struct {
-	DataPacket
-}
-
-func (DataPacket1) ID() byte {
-	return IDDataPacket1
-}
-
-func (DataPacket1) New() raklib.Packet {
-	return new(DataPacket1)
-}
-
-type DataPacket2 struct {
-	DataPacket
-}
-
-func (DataPacket2) ID() byte {
-	return IDDataPacket2
-}
-
-func (DataPacket2) New() raklib.Packet {
-	return new(DataPacket2)
-}
-
-type DataPacket3 struct {
-	DataPacket
-}
-
-func (DataPacket3) ID() byte {
-	return IDDataPacket3
-}
-
-func (DataPacket3) New() raklib.Packet {
-	return new(DataPacket3)
-}
-
-type DataPacket4 struct {
-	DataPacket
-}
-
-func (DataPacket4) ID() byte {
-	return IDDataPacket4
-}
-
-func (DataPacket4) New() raklib.Packet {
-	return new(DataPacket4)
-}
-
-type DataPacket5 struct {
-	DataPacket
-}
-
-func (DataPacket5) ID() byte {
-	return IDDataPacket5
-}
-
-func (DataPacket5) New() raklib.Packet {
-	return new(DataPacket5)
-}
-
-type DataPacket6 struct {
-	DataPacket
-}
-
-func (DataPacket6) ID() byte {
-	return IDDataPacket6
-}
-
-func (DataPacket6) New() raklib.Packet {
-	return new(DataPacket6)
-}
-
-type DataPacket7 struct {
-	DataPacket
-}
-
-func (DataPacket7) ID() byte {
-	return IDDataPacket7
-}
-
-func (DataPacket7) New() raklib.Packet {
-	return new(DataPacket7)
-}
-
-type DataPacket8 struct {
-	DataPacket
-}
-
-func (DataPacket8) ID() byte {
-	return IDDataPacket8
-}
-
-func (DataPacket8) New() raklib.Packet {
-	return new(DataPacket8)
-}
-
-type DataPacket9 struct {
-	DataPacket
-}
-
-func (DataPacket9) ID() byte {
-	return IDDataPacket9
-}
-
-func (DataPacket9) New() raklib.Packet {
-	return new(DataPacket9)
-}
-
-type DataPacketA struct {
-	DataPacket
-}
-
-func (DataPacketA) ID() byte {
-	return IDDataPacketA
-}
-
-func (DataPacketA) New() raklib.Packet {
-	return new(DataPacketA)
-}
-
-type DataPacketB struct {
-	DataPacket
-}
+func (bp *DataPacket) Decode() error {
+	if bp.Buffer == nil {
+		return raklib.NoSetBufferError{}
+	}
 
-func (DataPacketB) ID() byte {
-	return IDDataPacketB
-}
+	err := bp.Byte(&bp.Flags)
+	if err != nil {
+		return ErrTruncatedPacket
+	}
 
-func (DataPacketB) New() raklib.Packet {
-	return new(DataPacketB)
-}
+	if !bp.HasFlag(FlagValid) || bp.HasFlag(FlagACK|FlagNAK) {
+		return errors.New("the datagram isn't a data packet")
+	}
 
-type DataPacketC struct {
-	DataPacket
-}
+	err = bp.LTriad(&bp.Index)
+	if err != nil {
+		return ErrTruncatedPacket
+	}
 
-func (DataPacketC) ID() byte {
-	return IDDataPacketC
-}
+	bp.Packets = nil
 
-func (DataPacketC) New() raklib.Packet {
-	return new(DataPacketC)
-}
+	for bp.Buffer.Len() > 0 {
+		epk := &EncapsulatedPacket{}
 
-type DataPacketD struct {
-	DataPacket
-}
+		err = epk.Decode(&bp.Stream)
+		if err != nil {
+			return err
+		}
 
-func (DataPacketD) ID() byte {
-	return IDDataPacketD
-}
+		bp.Packets = append(bp.Packets, epk)
+	}
 
-func (DataPacketD) New() raklib.Packet {
-	return new(DataPacketD)
+	return nil
 }
 
-type DataPacketE struct {
-	DataPacket
-}
+// DecodeDatagram decodes a connected datagram by the header flags
+// It returns *ACKPacket, *NACKPacket or *DataPacket
+func DecodeDatagram(b []byte) (raklib.Packet, error) {
+	if len(b) == 0 || b[0]&FlagValid == 0 {
+		return nil, errors.New("the datagram isn't valid")
+	}
 
-func (DataPacketE) ID() byte {
-	return IDDataPacketE
-}
+	var pk interface {
+		raklib.Packet
+		SetBuffer(b []byte)
+	}
 
-func (DataPacketE) New() raklib.Packet {
-	return new(DataPacketE)
-}
+	switch {
+	case b[0]&FlagACK != 0:
+		pk = &ACKPacket{}
+	case b[0]&FlagNAK != 0:
+		pk = &NACKPacket{}
+	default:
+		pk = &DataPacket{}
+	}
 
-type DataPacketF struct {
-	DataPacket
-}
+	pk.SetBuffer(b)
 
-func (DataPacketF) ID() byte {
-	return IDDataPacketF
-}
+	err := pk.Decode()
+	if err != nil {
+		return nil, err
+	}
 
-func (DataPacketF) New() raklib.Packet {
-	return new(DataPacketF)
+	return pk, nil
 }
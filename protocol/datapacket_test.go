@@ -0,0 +1,230 @@
+package protocol
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// newTestPacket returns a packet which has all indexes set
+// Only the indexes used by the reliability are encoded
+func newTestPacket(reliability Reliability, split bool) *EncapsulatedPacket {
+	epk := &EncapsulatedPacket{
+		Reliability:   reliability,
+		ReliableIndex: 0x010203,
+		SequenceIndex: 0x040506,
+		OrderIndex:    0x070809,
+		OrderChannel:  5,
+		Body:          []byte{0xab},
+	}
+
+	if split {
+		epk.HasSplit = true
+		epk.SplitCount = 2
+		epk.SplitID = 7
+		epk.SplitIndex = 1
+	}
+
+	return epk
+}
+
+func TestEncapsulatedPacketHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		reliability Reliability
+		split       bool
+		want        []byte
+	}{
+		{
+			name:        "Unreliable",
+			reliability: Unreliable,
+			want:        []byte{0x00, 0x00, 0x08, 0xab},
+		},
+		{
+			name:        "UnreliableSequenced",
+			reliability: UnreliableSequenced,
+			want:        []byte{0x20, 0x00, 0x08, 0x06, 0x05, 0x04, 0x09, 0x08, 0x07, 0x05, 0xab},
+		},
+		{
+			name:        "Reliable",
+			reliability: Reliable,
+			want:        []byte{0x40, 0x00, 0x08, 0x03, 0x02, 0x01, 0xab},
+		},
+		{
+			name:        "ReliableOrdered",
+			reliability: ReliableOrdered,
+			want:        []byte{0x60, 0x00, 0x08, 0x03, 0x02, 0x01, 0x09, 0x08, 0x07, 0x05, 0xab},
+		},
+		{
+			name:        "ReliableSequenced",
+			reliability: ReliableSequenced,
+			want:        []byte{0x80, 0x00, 0x08, 0x03, 0x02, 0x01, 0x06, 0x05, 0x04, 0x09, 0x08, 0x07, 0x05, 0xab},
+		},
+		{
+			name:        "UnreliableWithACKReceipt",
+			reliability: UnreliableWithACKReceipt,
+			want:        []byte{0xa0, 0x00, 0x08, 0xab},
+		},
+		{
+			name:        "ReliableWithACKReceipt",
+			reliability: ReliableWithACKReceipt,
+			want:        []byte{0xc0, 0x00, 0x08, 0x03, 0x02, 0x01, 0xab},
+		},
+		{
+			name:        "ReliableOrderedWithACKReceipt",
+			reliability: ReliableOrderedWithACKReceipt,
+			want:        []byte{0xe0, 0x00, 0x08, 0x03, 0x02, 0x01, 0x09, 0x08, 0x07, 0x05, 0xab},
+		},
+		{
+			name:        "ReliableOrdered split",
+			reliability: ReliableOrdered,
+			split:       true,
+			want: []byte{0x70, 0x00, 0x08, 0x03, 0x02, 0x01, 0x09, 0x08, 0x07, 0x05,
+				0x00, 0x00, 0x00, 0x02, 0x00, 0x07, 0x00, 0x00, 0x00, 0x01, 0xab},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			epk := newTestPacket(test.reliability, test.split)
+
+			dpk := &DataPacket{
+				Index:   0x0a0b0c,
+				Packets: []*EncapsulatedPacket{epk},
+			}
+
+			err := dpk.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b := dpk.Bytes()
+			if !bytes.Equal(b[:DataPacketHeaderSize], []byte{FlagValid, 0x0c, 0x0b, 0x0a}) {
+				t.Errorf("datagram header = % x", b[:DataPacketHeaderSize])
+			}
+
+			got := b[DataPacketHeaderSize:]
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("frame = % x, want % x", got, test.want)
+			}
+
+			if epk.Len() != len(test.want) {
+				t.Errorf("Len() = %d, want %d", epk.Len(), len(test.want))
+			}
+
+			pk, err := DecodeDatagram(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := pk.(*DataPacket)
+			if decoded.Index != dpk.Index || len(decoded.Packets) != 1 {
+				t.Fatalf("decoded index %x with %d packets", decoded.Index, len(decoded.Packets))
+			}
+
+			// the indexes which aren't used by the reliability aren't sent
+			want := *epk
+			if !want.Reliability.IsReliable() {
+				want.ReliableIndex = 0
+			}
+
+			if !want.Reliability.IsSequenced() {
+				want.SequenceIndex = 0
+			}
+
+			if !want.Reliability.IsOrdered() {
+				want.OrderIndex = 0
+				want.OrderChannel = 0
+			}
+
+			if !reflect.DeepEqual(decoded.Packets[0], &want) {
+				t.Errorf("decoded %+v, want %+v", decoded.Packets[0], &want)
+			}
+		})
+	}
+}
+
+func TestDecodeDatagramTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{
+			name: "index",
+			b:    []byte{FlagValid, 0x00, 0x00},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "length",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x40, 0x00},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "reliable index",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x40, 0x00, 0x08, 0x01},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "order channel",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x60, 0x00, 0x08, 0x01, 0x00, 0x00, 0x02, 0x00, 0x00},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "split",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x10, 0x00, 0x08, 0x00, 0x00, 0x00, 0x02, 0x00},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "body",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0xab},
+			err:  ErrTruncatedPacket,
+		},
+		{
+			name: "zero length",
+			b:    []byte{FlagValid, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			err:  ErrInvalidLength,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeDatagram(test.b)
+			if err != test.err {
+				t.Errorf("err = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeDatagramPrefixes(t *testing.T) {
+	dpk := &DataPacket{
+		Packets: []*EncapsulatedPacket{newTestPacket(ReliableSequenced, true)},
+	}
+
+	err := dpk.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := dpk.Bytes()
+
+	// every cut inside the frame is detected
+	for n := DataPacketHeaderSize + 1; n < len(b); n++ {
+		_, err := DecodeDatagram(b[:n])
+		if err != ErrTruncatedPacket {
+			t.Errorf("%d of %d bytes: err = %v", n, len(b), err)
+		}
+	}
+}
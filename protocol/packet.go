@@ -13,6 +13,7 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"github.com/beito123/raklib"
 	"github.com/beito123/raklib/binary"
 )
@@ -34,6 +35,32 @@ func (base *BasePacket) Encode(pk raklib.Packet) error {
 	return nil
 }
 
+// handshakeTimesSize is the size of the two timestamps after the system addresses
+const handshakeTimesSize = 16
+
+// systemAddresses reads system addresses until only the timestamps of the handshake are left
+// The number of the addresses isn't sent and differs by implementations
+func (base *BasePacket) systemAddresses() ([]raklib.SystemAddress, error) {
+	var addrs []raklib.SystemAddress
+	for base.Buffer.Len() > handshakeTimesSize {
+		var addr raklib.SystemAddress
+
+		err := base.AddressSystemAddress(&addr)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// SetBuffer sets b to the buffer to decode
+func (base *BasePacket) SetBuffer(b []byte) {
+	base.Buffer = bytes.NewBuffer(b)
+}
+
 func (base *BasePacket) Decode(pk raklib.Packet) error {
 	if base.Buffer == nil {
 		return raklib.NoSetBufferError{}
@@ -86,8 +113,9 @@ func (pk *PingDataPacket) Decode() error {
 type UnconnectedPingPacket struct {
 	BasePacket
 
-	Time  int64
-	Magic string
+	Time       int64
+	Magic      string
+	ClientUUID int64
 }
 
 func (UnconnectedPingPacket) ID() byte {
@@ -104,12 +132,20 @@ func (pk *UnconnectedPingPacket) Encode() error {
 		return err
 	}
 
-	err = pk.Long(&pk.Time)
+	err = pk.PutLong(pk.Time)
 	if err != nil {
 		return err
 	}
 
-	pk.HexString(16, &pk.Magic)
+	err = pk.PutHexString(raklib.Magic)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.ClientUUID)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -120,21 +156,32 @@ func (pk *UnconnectedPingPacket) Decode() error {
 		return err
 	}
 
-	err = pk.PutLong(pk.Time)
+	err = pk.Long(&pk.Time)
 	if err != nil {
 		return err
 	}
 
-	err = pk.PutHexString(raklib.Magic)
-	if err != nil {
-		return err
+	pk.HexString(16, &pk.Magic)
+
+	// some old clients don't send the guid
+	if pk.Buffer.Len() >= 8 {
+		err = pk.Long(&pk.ClientUUID)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// UnconnectedPingOpenConnections is an unconnected ping,
+// which is answered only by servers which have free slots
 type UnconnectedPingOpenConnections struct {
 	BasePacket
+
+	Time       int64
+	Magic      string
+	ClientUUID int64
 }
 
 func (UnconnectedPingOpenConnections) ID() byte {
@@ -151,6 +198,21 @@ func (pk *UnconnectedPingOpenConnections) Encode() error {
 		return err
 	}
 
+	err = pk.PutLong(pk.Time)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutHexString(raklib.Magic)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.ClientUUID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -160,11 +222,29 @@ func (pk *UnconnectedPingOpenConnections) Decode() error {
 		return err
 	}
 
-	return err
+	err = pk.Long(&pk.Time)
+	if err != nil {
+		return err
+	}
+
+	pk.HexString(16, &pk.Magic)
+
+	// some old clients don't send the guid
+	if pk.Buffer.Len() >= 8 {
+		err = pk.Long(&pk.ClientUUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type PongDataPacket struct {
 	BasePacket
+
+	PingTime int64 // time of the ping which is answered
+	PongTime int64
 }
 
 func (PongDataPacket) ID() byte {
@@ -181,6 +261,16 @@ func (pk *PongDataPacket) Encode() error {
 		return err
 	}
 
+	err = pk.PutLong(pk.PingTime)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.PongTime)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -190,6 +280,16 @@ func (pk *PongDataPacket) Decode() error {
 		return err
 	}
 
+	err = pk.Long(&pk.PingTime)
+	if err != nil {
+		return err
+	}
+
+	err = pk.Long(&pk.PongTime)
+	if err != nil {
+		return err
+	}
+
 	return err
 }
 
@@ -198,7 +298,7 @@ type OpenConnectionRequest1Packet struct {
 
 	Magic    string
 	Protocol byte
-	MTU      []byte
+	MTU      uint16 // the packet is padded to the mtu size
 }
 
 func (OpenConnectionRequest1Packet) ID() byte {
@@ -225,9 +325,13 @@ func (pk *OpenConnectionRequest1Packet) Encode() error {
 		return err
 	}
 
-	m := make([]byte, 46) //
+	// padding, the size of the datagram with ip and udp headers is the mtu size
+	size := int(pk.MTU) - raklib.UDPHeaderSize - pk.Buffer.Len()
+	if size < 0 {
+		return errors.New("the mtu size is too small")
+	}
 
-	err = pk.Put(m)
+	err = pk.Put(make([]byte, size))
 	if err != nil {
 		return err
 	}
@@ -236,6 +340,12 @@ func (pk *OpenConnectionRequest1Packet) Encode() error {
 }
 
 func (pk *OpenConnectionRequest1Packet) Decode() error {
+	if pk.Buffer == nil {
+		return raklib.NoSetBufferError{}
+	}
+
+	size := pk.Buffer.Len()
+
 	err := pk.BasePacket.Decode(pk)
 	if err != nil {
 		return err
@@ -248,7 +358,9 @@ func (pk *OpenConnectionRequest1Packet) Decode() error {
 		return err
 	}
 
-	pk.MTU = pk.Get(-1)
+	pk.Get(-1) // padding
+
+	pk.MTU = uint16(size + raklib.UDPHeaderSize)
 
 	return nil
 }
@@ -436,6 +548,11 @@ func (pk *OpenConnectionReply2Packet) Encode() error {
 		return err
 	}
 
+	err = pk.PutShort(pk.MTU)
+	if err != nil {
+		return err
+	}
+
 	err = pk.PutByte(pk.Encryption)
 	if err != nil {
 		return err
@@ -467,14 +584,20 @@ func (pk *OpenConnectionReply2Packet) Decode() error {
 		return err
 	}
 
+	err = pk.Byte(&pk.Encryption)
+	if err != nil {
+		return err
+	}
+
 	return err
 }
 
 type ClientConnectDataPacket struct {
 	BasePacket
 
-	UUID int64
-	Time int64
+	UUID     int64
+	Time     int64
+	Security bool // raklib doesn't support security, so it's always false
 }
 
 func (ClientConnectDataPacket) ID() byte {
@@ -501,6 +624,11 @@ func (pk *ClientConnectDataPacket) Encode() error {
 		return err
 	}
 
+	err = pk.PutBool(pk.Security)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -520,7 +648,15 @@ func (pk *ClientConnectDataPacket) Decode() error {
 		return err
 	}
 
-	return err
+	// some old clients don't send it
+	if pk.Buffer.Len() >= 1 {
+		err = pk.Bool(&pk.Security)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type ServerHandshakeDataPacket struct {
@@ -528,7 +664,7 @@ type ServerHandshakeDataPacket struct {
 
 	ClientAddr      raklib.SystemAddress
 	SystemIndex     uint16
-	SystemAddresses [10]raklib.SystemAddress
+	SystemAddresses []raklib.SystemAddress // RakNet sends 10, Bedrock sends 20
 	RequestTime     int64
 	Time            int64
 }
@@ -557,8 +693,8 @@ func (pk *ServerHandshakeDataPacket) Encode() error {
 		return err
 	}
 
-	for i := 0; i < 10; i++ {
-		err = pk.PutAddressSystemAddress(pk.SystemAddresses[i])
+	for _, addr := range pk.SystemAddresses {
+		err = pk.PutAddressSystemAddress(addr)
 		if err != nil {
 			return err
 		}
@@ -593,11 +729,9 @@ func (pk *ServerHandshakeDataPacket) Decode() error {
 		return err
 	}
 
-	for i := 0; i < 10; i++ {
-		err = pk.AddressSystemAddress(&pk.SystemAddresses[i])
-		if err != nil {
-			return err
-		}
+	pk.SystemAddresses, err = pk.systemAddresses()
+	if err != nil {
+		return err
 	}
 
 	err = pk.Long(&pk.RequestTime)
@@ -613,8 +747,14 @@ func (pk *ServerHandshakeDataPacket) Decode() error {
 	return nil
 }
 
+// ClientHandshakeDataPacket is NewIncomingConnection in RakNet
 type ClientHandshakeDataPacket struct {
 	BasePacket
+
+	ServerAddr      raklib.SystemAddress
+	SystemAddresses []raklib.SystemAddress // RakNet sends 10, Bedrock sends 20
+	RequestTime     int64                  // Time of ServerHandshakeDataPacket
+	Time            int64
 }
 
 func (ClientHandshakeDataPacket) ID() byte {
@@ -631,6 +771,28 @@ func (pk *ClientHandshakeDataPacket) Encode() error {
 		return err
 	}
 
+	err = pk.PutAddressSystemAddress(pk.ServerAddr)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range pk.SystemAddresses {
+		err = pk.PutAddressSystemAddress(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pk.PutLong(pk.RequestTime)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.Time)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -640,7 +802,27 @@ func (pk *ClientHandshakeDataPacket) Decode() error {
 		return err
 	}
 
-	return err
+	err = pk.AddressSystemAddress(&pk.ServerAddr)
+	if err != nil {
+		return err
+	}
+
+	pk.SystemAddresses, err = pk.systemAddresses()
+	if err != nil {
+		return err
+	}
+
+	err = pk.Long(&pk.RequestTime)
+	if err != nil {
+		return err
+	}
+
+	err = pk.Long(&pk.Time)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 type ClientDisconnectDataPacket struct {
@@ -683,6 +865,11 @@ func (pk *ClientDisconnectDataPacket) Decode() error {
 		return err
 	}
 
+	// RakNet sends only the id
+	if pk.Buffer.Len() == 0 {
+		return nil
+	}
+
 	err = pk.Long(&pk.Time)
 	if err != nil {
 		return err
@@ -758,10 +945,7 @@ func (pk *UnconnectedPongPacket) Decode() error {
 		return err
 	}
 
-	err = pk.String(&pk.Magic)
-	if err != nil {
-		return err
-	}
+	pk.HexString(16, &pk.Magic)
 
 	err = pk.String(&pk.ServerName)
 	if err != nil {
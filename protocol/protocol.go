@@ -11,15 +11,38 @@ package protocol
 	(at your option) any later version.
 */
 
-import "github.com/beito123/raklib"
+import (
+	"errors"
+	"strconv"
+	"sync"
 
+	"github.com/beito123/raklib"
+)
+
+var (
+	// ErrUnknownPacket is returned when a packet of the id isn't registered
+	ErrUnknownPacket = errors.New("the packet isn't registered")
+)
+
+// Protocol is a registry of packets by id
+// Raknet packets and user messages are registered separately,
+// because user messages are sent in connected datagrams and their ids overlap with datagram ids
+// Datagrams aren't registered, they're decoded by the header flags with DecodeDatagram
 type Protocol struct {
-	packets []raklib.Packet
+	mutex    sync.RWMutex
+	packets  [256]raklib.Packet
+	messages [256]raklib.Packet
 }
 
-func (pro *Protocol) registerPackets() {
-	pro.packets = make([]raklib.Packet, 0xff)
+// NewProtocol returns a new Protocol which has the Raknet packets
+func NewProtocol() *Protocol {
+	pro := &Protocol{}
+	pro.registerPackets()
 
+	return pro
+}
+
+func (pro *Protocol) registerPackets() {
 	pro.packets[IDPingDataPacket] = &PingDataPacket{}
 	pro.packets[IDUnconnectedPing] = &UnconnectedPingPacket{}
 	pro.packets[IDUnconnectedPingOpenConnections] = &UnconnectedPingOpenConnections{}
@@ -32,30 +55,114 @@ func (pro *Protocol) registerPackets() {
 	pro.packets[IDServerHandshakeDataPacket] = &ServerHandshakeDataPacket{}
 	pro.packets[IDClientHandshakeDataPacket] = &ClientHandshakeDataPacket{}
 	pro.packets[IDClientDisconnectDataPacket] = &ClientDisconnectDataPacket{}
-	pro.packets[IDDataPacket0] = &DataPacket0{}
-	pro.packets[IDDataPacket1] = &DataPacket1{}
-	pro.packets[IDDataPacket2] = &DataPacket2{}
-	pro.packets[IDDataPacket3] = &DataPacket3{}
-	pro.packets[IDDataPacket4] = &DataPacket4{}
-	pro.packets[IDDataPacket5] = &DataPacket5{}
-	pro.packets[IDDataPacket6] = &DataPacket6{}
-	pro.packets[IDDataPacket7] = &DataPacket7{}
-	pro.packets[IDDataPacket8] = &DataPacket8{}
-	pro.packets[IDDataPacket9] = &DataPacket9{}
-	pro.packets[IDDataPacketA] = &DataPacketA{}
-	pro.packets[IDDataPacketB] = &DataPacketB{}
-	pro.packets[IDDataPacketC] = &DataPacketC{}
-	pro.packets[IDDataPacketD] = &DataPacketD{}
-	pro.packets[IDDataPacketE] = &DataPacketE{}
-	pro.packets[IDDataPacketF] = &DataPacketF{}
 	pro.packets[IDUnconnectedPong] = &UnconnectedPongPacket{}
 }
 
-func (pro *Protocol) Packet(id byte) raklib.Packet {
+// Register registers pk as the Raknet packet of pk.ID()
+// A registered packet including the built-in packets is overridden
+func (pro *Protocol) Register(pk raklib.Packet) {
+	pro.mutex.Lock()
+	defer pro.mutex.Unlock()
+
+	pro.packets[pk.ID()] = pk
+}
+
+// Lookup returns a new packet of id
+// It returns false if the packet isn't registered
+func (pro *Protocol) Lookup(id byte) (raklib.Packet, bool) {
+	pro.mutex.RLock()
 	pk := pro.packets[id]
+	pro.mutex.RUnlock()
+
+	if pk == nil {
+		return nil, false
+	}
+
+	return pk.New(), true
+}
+
+// Packet returns a new packet of id, or nil if the packet isn't registered
+func (pro *Protocol) Packet(id byte) raklib.Packet {
+	pk, _ := pro.Lookup(id)
+
+	return pk
+}
+
+// RegisterMessage registers pk as the user message of pk.ID()
+// The id must be IDUserPacketEnum or larger
+func (pro *Protocol) RegisterMessage(pk raklib.Packet) error {
+	if pk.ID() < IDUserPacketEnum {
+		return errors.New("user message ids start at 0x" + strconv.FormatInt(IDUserPacketEnum, 16))
+	}
+
+	pro.mutex.Lock()
+	defer pro.mutex.Unlock()
+
+	pro.messages[pk.ID()] = pk
+
+	return nil
+}
+
+// LookupMessage returns a new user message of id
+// It returns false if the message isn't registered
+func (pro *Protocol) LookupMessage(id byte) (raklib.Packet, bool) {
+	pro.mutex.RLock()
+	pk := pro.messages[id]
+	pro.mutex.RUnlock()
+
 	if pk == nil {
-		return nil
+		return nil, false
+	}
+
+	return pk.New(), true
+}
+
+// Decode decodes b as the Raknet packet of the first byte
+// It returns ErrUnknownPacket if the packet isn't registered
+func (pro *Protocol) Decode(b []byte) (raklib.Packet, error) {
+	if len(b) == 0 {
+		return nil, ErrTruncatedPacket
+	}
+
+	pk, ok := pro.Lookup(b[0])
+	if !ok {
+		return nil, ErrUnknownPacket
+	}
+
+	return decode(pk, b)
+}
+
+// DecodeMessage decodes b as the user message of the first byte
+// It returns ErrUnknownPacket if the message isn't registered
+func (pro *Protocol) DecodeMessage(b []byte) (raklib.Packet, error) {
+	if len(b) == 0 {
+		return nil, ErrTruncatedPacket
+	}
+
+	pk, ok := pro.LookupMessage(b[0])
+	if !ok {
+		return nil, ErrUnknownPacket
+	}
+
+	return decode(pk, b)
+}
+
+// decode sets b to the buffer of pk and decodes it
+// pk needs SetBuffer, which BasePacket has
+func decode(pk raklib.Packet, b []byte) (raklib.Packet, error) {
+	bpk, ok := pk.(interface {
+		SetBuffer(b []byte)
+	})
+	if !ok {
+		return nil, errors.New("the packet can't set a buffer")
+	}
+
+	bpk.SetBuffer(b)
+
+	err := pk.Decode()
+	if err != nil {
+		return nil, err
 	}
 
-	return pk.New()
+	return pk, nil
 }
@@ -0,0 +1,165 @@
+package protocol
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"testing"
+
+	"github.com/beito123/raklib"
+)
+
+// testPingPacket overrides the built-in PingDataPacket
+type testPingPacket struct {
+	PingDataPacket
+}
+
+func (testPingPacket) New() raklib.Packet {
+	return new(testPingPacket)
+}
+
+// testMessage is a user message which has a byte
+type testMessage struct {
+	BasePacket
+
+	id    byte
+	Value byte
+}
+
+func (pk testMessage) ID() byte {
+	return pk.id
+}
+
+func (pk testMessage) New() raklib.Packet {
+	return &testMessage{id: pk.id}
+}
+
+func (pk *testMessage) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	return pk.PutByte(pk.Value)
+}
+
+func (pk *testMessage) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	return pk.Byte(&pk.Value)
+}
+
+func TestProtocolRegister(t *testing.T) {
+	pro := NewProtocol()
+
+	if _, ok := pro.Packet(IDPingDataPacket).(*PingDataPacket); !ok {
+		t.Fatal("the built-in packet isn't registered")
+	}
+
+	pro.Register(&testPingPacket{})
+
+	ping := &PingDataPacket{Time: 1234}
+
+	err := ping.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := pro.Decode(ping.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := pk.(*testPingPacket)
+	if !ok {
+		t.Fatalf("decoded as %T", pk)
+	}
+
+	if got.Time != ping.Time {
+		t.Errorf("Time = %d, want %d", got.Time, ping.Time)
+	}
+
+	// the other protocols aren't changed
+	if _, ok := NewProtocol().Packet(IDPingDataPacket).(*PingDataPacket); !ok {
+		t.Error("the override is shared between protocols")
+	}
+}
+
+func TestProtocolLookupUnknown(t *testing.T) {
+	pro := NewProtocol()
+
+	// unregistered ids, datagrams and user messages aren't found in the Raknet packets
+	ids := []byte{0x04, IDAdvertiseSystem, FlagValid, FlagValid | 0x04, IDNACK, IDACK, IDUserPacketEnum, 0xff}
+	for _, id := range ids {
+		if pk, ok := pro.Lookup(id); ok || pk != nil {
+			t.Errorf("Lookup(0x%02x) = %T, %v", id, pk, ok)
+		}
+
+		if pk := pro.Packet(id); pk != nil {
+			t.Errorf("Packet(0x%02x) = %T", id, pk)
+		}
+
+		if _, err := pro.Decode([]byte{id}); err != ErrUnknownPacket {
+			t.Errorf("Decode(0x%02x) err = %v", id, err)
+		}
+	}
+
+	if _, err := pro.Decode(nil); err != ErrTruncatedPacket {
+		t.Errorf("Decode(nil) err = %v", err)
+	}
+}
+
+func TestProtocolRegisterMessage(t *testing.T) {
+	pro := NewProtocol()
+
+	for _, id := range []byte{IDPingDataPacket, IDClientDisconnectDataPacket, FlagValid, IDUserPacketEnum - 1} {
+		if err := pro.RegisterMessage(&testMessage{id: id}); err == nil {
+			t.Errorf("registered the message of 0x%02x", id)
+		}
+
+		if _, ok := pro.LookupMessage(id); ok {
+			t.Errorf("LookupMessage(0x%02x) = true", id)
+		}
+	}
+
+	err := pro.RegisterMessage(&testMessage{id: IDUserPacketEnum})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &testMessage{id: IDUserPacketEnum, Value: 7}
+
+	err = msg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := pro.DecodeMessage(msg.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := pk.(*testMessage); !ok || got.Value != 7 {
+		t.Errorf("decoded %+v", pk)
+	}
+
+	// messages aren't decoded as Raknet packets and the other way around
+	if _, err := pro.Decode(msg.Bytes()); err != ErrUnknownPacket {
+		t.Errorf("Decode() err = %v", err)
+	}
+
+	if _, err := pro.DecodeMessage([]byte{IDUserPacketEnum + 1}); err != ErrUnknownPacket {
+		t.Errorf("DecodeMessage() err = %v", err)
+	}
+}
@@ -12,7 +12,9 @@ package raklib
 */
 
 import (
+	"errors"
 	"net"
+	"net/netip"
 	"strconv"
 )
 
@@ -28,6 +30,11 @@ const (
 	Magic = "00ffff00fefefefefdfdfdfd12345678"
 )
 
+const (
+	// UDPHeaderSize is the size of ip and udp headers, which is included in the mtu size
+	UDPHeaderSize = 28
+)
+
 // Packet is basic Raknet packet interface
 type Packet interface {
 	ID() byte
@@ -37,28 +44,90 @@ type Packet interface {
 }
 
 // SystemAddress is internal address for Raknet
+// It's comparable, so it can be used as a map key.
+// IPv4-mapped IPv6 addresses are stored as IPv4 addresses,
+// so the same host has the same SystemAddress on IPv4 and IPv6 sockets
 type SystemAddress struct {
-	IP   net.IP
-	Port uint16
+	addrPort netip.AddrPort
+}
+
+// NewSystemAddress returns a new SystemAddress from an ip address and a port
+func NewSystemAddress(addr netip.Addr, port uint16) SystemAddress {
+	return SystemAddress{
+		addrPort: netip.AddrPortFrom(addr.Unmap(), port),
+	}
+}
+
+// NewSystemAddressUDP returns a new SystemAddress from UDPAddr
+func NewSystemAddressUDP(addr *net.UDPAddr) SystemAddress {
+	ap := addr.AddrPort()
+
+	return NewSystemAddress(ap.Addr(), ap.Port())
+}
+
+// NewSystemAddressBytes returns a new SystemAddress from 4 or 16 bytes
+func NewSystemAddressBytes(addr []byte, port uint16) (SystemAddress, error) {
+	ip, ok := netip.AddrFromSlice(addr)
+	if !ok {
+		return SystemAddress{}, errors.New("invalid address length: " + strconv.Itoa(len(addr)))
+	}
+
+	return NewSystemAddress(ip, port), nil
+}
+
+// ParseSystemAddress parses a SystemAddress from string
+// Format: 192.168.11.1:8080, [fc00::]:8080, [fe80::1%eth0]:8080
+func ParseSystemAddress(s string) (SystemAddress, error) {
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return SystemAddress{}, err
+	}
+
+	return NewSystemAddress(ap.Addr(), ap.Port()), nil
+}
+
+// Addr returns the ip address
+func (addr SystemAddress) Addr() netip.Addr {
+	return addr.addrPort.Addr()
+}
+
+// Port returns the port
+func (addr SystemAddress) Port() uint16 {
+	return addr.addrPort.Port()
+}
+
+// AddrPort returns the address as netip.AddrPort
+func (addr SystemAddress) AddrPort() netip.AddrPort {
+	return addr.addrPort
+}
+
+// UDPAddr returns the address as UDPAddr
+func (addr SystemAddress) UDPAddr() *net.UDPAddr {
+	return net.UDPAddrFromAddrPort(addr.addrPort)
+}
+
+// IsValid returns whether the address is set
+func (addr SystemAddress) IsValid() bool {
+	return addr.addrPort.IsValid()
 }
 
 // SetLoopback sets loopback address
 func (addr *SystemAddress) SetLoopback() {
-	if len(addr.IP) == net.IPv4len {
-		addr.IP = net.ParseIP("127.0.0.1")
+	if addr.Version() == 4 {
+		addr.addrPort = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), addr.Port())
 	} else {
-		addr.IP = net.IPv6loopback // "::1"
+		addr.addrPort = netip.AddrPortFrom(netip.IPv6Loopback(), addr.Port()) // "::1"
 	}
 }
 
 // IsLoopback returns whether this is loopback address
-func (addr *SystemAddress) IsLoopback() bool {
-	return addr.IP.IsLoopback()
+func (addr SystemAddress) IsLoopback() bool {
+	return addr.Addr().IsLoopback()
 }
 
 // Version returns the ip address version (4 or 6)
-func (addr *SystemAddress) Version() int {
-	if len(addr.IP) == net.IPv6len {
+func (addr SystemAddress) Version() int {
+	if addr.Addr().Is6() {
 		return 6
 	}
 
@@ -66,32 +135,12 @@ func (addr *SystemAddress) Version() int {
 }
 
 // Equal returns whether sub is the same address
-func (addr *SystemAddress) Equal(sub *SystemAddress) bool {
-	return addr.IP.Equal(sub.IP) && addr.Port == sub.Port
+func (addr SystemAddress) Equal(sub SystemAddress) bool {
+	return addr == sub
 }
 
 // String returns as string
-// Format: 192.168.11.1:8080, [fc00::]:8080
-func (addr *SystemAddress) String() string {
-	if len(addr.IP) == net.IPv6len {
-		return "[" + addr.IP.String() + "]:" + strconv.Itoa(int(addr.Port))
-	}
-
-	return addr.IP.String() + ":" + strconv.Itoa(int(addr.Port))
-}
-
-// NewSystemAddress returns a new SystemAddress from string
-func NewSystemAddress(addr string, port uint16) *SystemAddress {
-	return &SystemAddress{
-		IP:   net.ParseIP(addr),
-		Port: port,
-	}
-}
-
-// NewSystemAddress returns a new SystemAddress from bytes
-func NewSystemAddressBytes(addr []byte, port uint16) *SystemAddress {
-	return &SystemAddress{
-		IP:   net.IP(addr).To16(),
-		Port: port,
-	}
+// Format: 192.168.11.1:8080, [fc00::]:8080, [fe80::1%eth0]:8080
+func (addr SystemAddress) String() string {
+	return addr.addrPort.String()
 }
\ No newline at end of file
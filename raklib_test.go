@@ -0,0 +1,133 @@
+package raklib
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseSystemAddress(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string // String() of the address
+		version int
+	}{
+		{
+			s:       "192.168.11.1:8080",
+			want:    "192.168.11.1:8080",
+			version: 4,
+		},
+		{
+			s:       "[fc00::]:8080",
+			want:    "[fc00::]:8080",
+			version: 6,
+		},
+		{
+			s:       "[fe80::1%eth0]:19132",
+			want:    "[fe80::1%eth0]:19132",
+			version: 6,
+		},
+		{
+			s:       "[::ffff:192.168.11.1]:8080",
+			want:    "192.168.11.1:8080",
+			version: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			addr, err := ParseSystemAddress(test.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if addr.String() != test.want {
+				t.Errorf("String() = %s, want %s", addr, test.want)
+			}
+
+			if addr.Version() != test.version {
+				t.Errorf("Version() = %d, want %d", addr.Version(), test.version)
+			}
+
+			// the string can be parsed again
+			parsed, err := ParseSystemAddress(addr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !parsed.Equal(addr) {
+				t.Errorf("parsed %s again as %s", addr, parsed)
+			}
+		})
+	}
+}
+
+func TestParseSystemAddressErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"192.168.11.1",
+		"192.168.11.1:",
+		"192.168.11.1:65536",
+		"192.168.11.256:8080",
+		"fc00:::8080",
+		"[fc00::]",
+		"localhost:8080",
+	}
+
+	for _, s := range tests {
+		t.Run(s, func(t *testing.T) {
+			addr, err := ParseSystemAddress(s)
+			if err == nil {
+				t.Errorf("parsed as %s", addr)
+			}
+		})
+	}
+}
+
+func TestSystemAddressMapped(t *testing.T) {
+	v4, err := ParseSystemAddress("192.168.11.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped, err := ParseSystemAddress("[::ffff:192.168.11.1]:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udp := NewSystemAddressUDP(&net.UDPAddr{
+		IP:   net.ParseIP("192.168.11.1").To16(),
+		Port: 8080,
+	})
+
+	// a client has one session on IPv4 and IPv6 sockets
+	sessions := map[SystemAddress]int{v4: 1}
+	for _, addr := range []SystemAddress{mapped, udp} {
+		if !addr.Equal(v4) {
+			t.Errorf("%s isn't equal to %s", addr, v4)
+		}
+
+		if sessions[addr] != 1 {
+			t.Errorf("%s isn't found in the map", addr)
+		}
+	}
+
+	other, err := ParseSystemAddress("192.168.11.1:8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := sessions[other]; ok {
+		t.Errorf("%s is found in the map", other)
+	}
+}
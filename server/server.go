@@ -11,3 +11,424 @@ package server
 	(at your option) any later version.
 */
 
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+	"github.com/beito123/raklib/session"
+)
+
+const (
+	// MaxMTU is the largest mtu size the server accepts
+	MaxMTU = 1492
+
+	// MinMTU is the smallest mtu size the server accepts
+	MinMTU = 400
+)
+
+// Handler handles events of sessions on the server
+// OnOpen is called after the connected handshake is finished,
+// OnPacket for each received message and OnClose when the session ends
+type Handler = session.Handler
+
+// Server is a Raknet server listening on udp addresses
+// Sessions on all addresses share one session table
+type Server struct {
+	// Name is the server name sent in unconnected pongs
+	Name string
+
+	// GUID is the unique id of the server
+	GUID int64
+
+	// MaxConnections is the maximum number of sessions
+	MaxConnections int
+
+	Handler Handler
+
+	// SessionConfig is the configuration of new sessions
+	// Its Protocol decodes unconnected packets of the server too
+	SessionConfig session.Config
+
+	conns    []*net.UDPConn
+	sessions map[raklib.SystemAddress]*session.Session
+	mutex    sync.RWMutex
+	closing  chan struct{}
+	once     sync.Once
+}
+
+// NewServer returns a new Server
+func NewServer(handler Handler) *Server {
+	return &Server{
+		GUID:           rand.Int63(),
+		MaxConnections: 20,
+		Handler:        handler,
+		SessionConfig:  session.DefaultConfig(),
+		sessions:       make(map[raklib.SystemAddress]*session.Session),
+		closing:        make(chan struct{}),
+	}
+}
+
+// Listen binds the server to a udp address
+// It can be called more than once to listen on several addresses before Serve
+func (ser *Server) Listen(address string) error {
+	return ser.listen("udp", address)
+}
+
+// ListenDualStack binds the server to port on both IPv4 and IPv6
+// If port is 0, the same port chosen for IPv4 is used for IPv6
+func (ser *Server) ListenDualStack(port int) error {
+	err := ser.listen("udp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	port = ser.conns[len(ser.conns)-1].LocalAddr().(*net.UDPAddr).Port
+
+	// udp6 sockets only receive IPv6 datagrams, so they don't overlap with the IPv4 socket
+	err = ser.listen("udp6", net.JoinHostPort("::", strconv.Itoa(port)))
+	if err != nil {
+		conn := ser.conns[len(ser.conns)-1]
+		ser.conns = ser.conns[:len(ser.conns)-1]
+		conn.Close()
+
+		return err
+	}
+
+	return nil
+}
+
+func (ser *Server) listen(network string, address string) error {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return err
+	}
+
+	ser.conns = append(ser.conns, conn)
+
+	return nil
+}
+
+// Serve reads datagrams and updates sessions until the server is closed
+func (ser *Server) Serve() error {
+	if len(ser.conns) == 0 {
+		return errors.New("the server isn't listening")
+	}
+
+	ser.SessionConfig = ser.SessionConfig.WithDefaults()
+
+	go ser.tick()
+
+	errs := make(chan error, len(ser.conns))
+	for _, conn := range ser.conns {
+		go func(conn *net.UDPConn) {
+			errs <- ser.serve(conn)
+		}(conn)
+	}
+
+	for range ser.conns {
+		err := <-errs
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serve reads datagrams from conn until the server is closed
+func (ser *Server) serve(conn *net.UDPConn) error {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			select {
+			case <-ser.closing:
+				return nil
+			default:
+			}
+
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		b := make([]byte, n)
+		copy(b, buf[:n])
+
+		ser.handle(b, addr, conn)
+	}
+}
+
+// ListenAndServe binds the server to a udp address and serves
+func (ser *Server) ListenAndServe(address string) error {
+	err := ser.Listen(address)
+	if err != nil {
+		return err
+	}
+
+	return ser.Serve()
+}
+
+// Close closes the server and all sessions
+// Calling Close again does nothing
+func (ser *Server) Close() error {
+	closed := false
+	ser.once.Do(func() {
+		close(ser.closing)
+		closed = true
+	})
+
+	if !closed {
+		return nil
+	}
+
+	for _, sess := range ser.removeSessions(func(*session.Session) bool { return true }) {
+		sess.Close(session.ReasonServerClosed)
+	}
+
+	var err error
+	for _, conn := range ser.conns {
+		e := conn.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Addr returns the first local address of the server
+func (ser *Server) Addr() net.Addr {
+	if len(ser.conns) == 0 {
+		return nil
+	}
+
+	return ser.conns[0].LocalAddr()
+}
+
+// Addrs returns all local addresses of the server
+func (ser *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(ser.conns))
+	for _, conn := range ser.conns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+
+	return addrs
+}
+
+// Session returns the session of addr, or nil if it doesn't exist
+func (ser *Server) Session(addr raklib.SystemAddress) *session.Session {
+	ser.mutex.RLock()
+	defer ser.mutex.RUnlock()
+
+	return ser.sessions[addr]
+}
+
+// hasFreeSlots returns whether the server accepts more sessions
+func (ser *Server) hasFreeSlots() bool {
+	ser.mutex.RLock()
+	defer ser.mutex.RUnlock()
+
+	return len(ser.sessions) < ser.MaxConnections
+}
+
+// Sessions returns all sessions
+func (ser *Server) Sessions() []*session.Session {
+	ser.mutex.RLock()
+	defer ser.mutex.RUnlock()
+
+	sessions := make([]*session.Session, 0, len(ser.sessions))
+	for _, sess := range ser.sessions {
+		sessions = append(sessions, sess)
+	}
+
+	return sessions
+}
+
+func (ser *Server) tick() {
+	ticker := time.NewTicker(ser.SessionConfig.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ser.closing:
+			return
+		case now := <-ticker.C:
+			removed := ser.removeSessions(func(sess *session.Session) bool {
+				return sess.IsClosed() || sess.IsTimedOut(now)
+			})
+
+			for _, sess := range removed {
+				sess.Close(session.ReasonTimeout)
+			}
+
+			for _, sess := range ser.Sessions() {
+				sess.Update(now)
+			}
+		}
+	}
+}
+
+// removeSessions removes sessions matched by fn from the table and returns them
+// Sessions are closed by the caller without holding the lock,
+// because handlers may call back into the server
+func (ser *Server) removeSessions(fn func(sess *session.Session) bool) []*session.Session {
+	ser.mutex.Lock()
+	defer ser.mutex.Unlock()
+
+	var removed []*session.Session
+	for key, sess := range ser.sessions {
+		if fn(sess) {
+			removed = append(removed, sess)
+			delete(ser.sessions, key)
+		}
+	}
+
+	return removed
+}
+
+// handle handles a datagram received on conn
+// The address is normalized, so a client has one session on IPv4 and IPv6 sockets
+func (ser *Server) handle(b []byte, udpAddr netip.AddrPort, conn *net.UDPConn) {
+	addr := raklib.NewSystemAddress(udpAddr.Addr(), udpAddr.Port())
+
+	// Connected datagrams all have the valid flag, and unconnected packets don't
+	if b[0]&protocol.FlagValid != 0 {
+		sess := ser.Session(addr)
+		if sess != nil {
+			sess.HandleDatagram(b)
+		}
+
+		return
+	}
+
+	ser.handleUnconnected(b, conn, udpAddr, addr)
+}
+
+func (ser *Server) handleUnconnected(b []byte, conn *net.UDPConn, udpAddr netip.AddrPort, addr raklib.SystemAddress) {
+	pk, err := ser.SessionConfig.Protocol.Decode(b)
+	if err != nil {
+		return
+	}
+
+	switch pk := pk.(type) {
+	case *protocol.UnconnectedPingPacket:
+		if pk.Magic != raklib.Magic {
+			return
+		}
+
+		ser.sendPong(pk.Time, conn, udpAddr)
+	case *protocol.UnconnectedPingOpenConnections:
+		if pk.Magic != raklib.Magic || !ser.hasFreeSlots() {
+			return
+		}
+
+		ser.sendPong(pk.Time, conn, udpAddr)
+	case *protocol.OpenConnectionRequest1Packet:
+		if pk.Magic != raklib.Magic || pk.Protocol != raklib.ProtocolVersion {
+			return
+		}
+
+		// The client probes mtu sizes from the largest, so the request is small enough
+		// to pass the network. The smaller of it and the maximum is agreed on
+		if pk.MTU < MinMTU {
+			return
+		}
+
+		mtu := pk.MTU
+		if mtu > MaxMTU {
+			mtu = MaxMTU
+		}
+
+		reply := &protocol.OpenConnectionReply1Packet{
+			ServerUUID: ser.GUID,
+			Security:   false,
+			MTU:        mtu,
+		}
+
+		ser.sendPacket(reply, conn, udpAddr)
+	case *protocol.OpenConnectionRequest2Packet:
+		if pk.Magic != raklib.Magic {
+			return
+		}
+
+		if pk.MTU < MinMTU || pk.MTU > MaxMTU {
+			return
+		}
+
+		ser.mutex.Lock()
+		// A resent request keeps the session of the handshake,
+		// otherwise the client has connected again from the same address
+		old, ok := ser.sessions[addr]
+		if ok && (old.State() != session.StateConnecting || old.GUID() != pk.ClientUUID) {
+			delete(ser.sessions, addr)
+		} else {
+			old = nil
+		}
+
+		if _, ok := ser.sessions[addr]; !ok {
+			if len(ser.sessions) >= ser.MaxConnections {
+				ser.mutex.Unlock()
+				return
+			}
+
+			ser.sessions[addr] = session.NewSession(conn, net.UDPAddrFromAddrPort(udpAddr), addr, pk.MTU, pk.ClientUUID, ser.Handler, ser.SessionConfig)
+		}
+		ser.mutex.Unlock()
+
+		// the old session is closed without the lock, because handlers may call back into the server
+		if old != nil {
+			old.Abort(session.ReasonReplaced)
+		}
+
+		reply := &protocol.OpenConnectionReply2Packet{
+			ServerUUID:    ser.GUID,
+			ClientAddress: addr,
+			MTU:           pk.MTU,
+		}
+
+		ser.sendPacket(reply, conn, udpAddr)
+	}
+}
+
+// sendPong replies to an unconnected ping sent at pingTime
+func (ser *Server) sendPong(pingTime int64, conn *net.UDPConn, addr netip.AddrPort) error {
+	pong := &protocol.UnconnectedPongPacket{
+		PingID:     pingTime,
+		ServerID:   ser.GUID,
+		ServerName: ser.Name,
+	}
+
+	return ser.sendPacket(pong, conn, addr)
+}
+
+// packet is an encoded packet which has a buffer
+type packet interface {
+	raklib.Packet
+	Bytes() []byte
+}
+
+func (ser *Server) sendPacket(pk packet, conn *net.UDPConn, addr netip.AddrPort) error {
+	err := pk.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.WriteToUDPAddrPort(pk.Bytes(), addr)
+
+	return err
+}
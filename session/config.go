@@ -0,0 +1,94 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"time"
+
+	"github.com/beito123/raklib/protocol"
+)
+
+// Config is the configuration of a session
+type Config struct {
+	// MaxSplitCount is the maximum number of fragments of a split packet
+	MaxSplitCount int
+
+	// MaxSplitPackets is the maximum number of split packets reassembled at once
+	MaxSplitPackets int
+
+	// SplitTimeout is the time until an incomplete split packet is dropped
+	SplitTimeout time.Duration
+
+	// FlushInterval is the interval to send queued packets in batched datagrams
+	FlushInterval time.Duration
+
+	// Protocol is the registry of packets and user messages received by a session
+	Protocol *protocol.Protocol
+
+	// SystemAddressCount is the number of system addresses sent in the connected handshake
+	// RakNet sends 10 and Bedrock sends 20
+	SystemAddressCount int
+
+	// Congestion returns a new congestion controller for a session
+	// mtu is the maximum datagram size without ip and udp headers
+	Congestion func(mtu int) CongestionController
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxSplitCount:      128,
+		MaxSplitPackets:    16,
+		SplitTimeout:       30 * time.Second,
+		FlushInterval:      10 * time.Millisecond,
+		Protocol:           protocol.NewProtocol(),
+		SystemAddressCount: 10,
+		Congestion: func(mtu int) CongestionController {
+			return NewSlidingWindow(mtu)
+		},
+	}
+}
+
+// WithDefaults returns the configuration with zero fields set to the default values
+func (config Config) WithDefaults() Config {
+	def := DefaultConfig()
+
+	if config.MaxSplitCount <= 0 {
+		config.MaxSplitCount = def.MaxSplitCount
+	}
+
+	if config.MaxSplitPackets <= 0 {
+		config.MaxSplitPackets = def.MaxSplitPackets
+	}
+
+	if config.SplitTimeout <= 0 {
+		config.SplitTimeout = def.SplitTimeout
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = def.FlushInterval
+	}
+
+	if config.Protocol == nil {
+		config.Protocol = def.Protocol
+	}
+
+	if config.SystemAddressCount <= 0 {
+		config.SystemAddressCount = def.SystemAddressCount
+	}
+
+	if config.Congestion == nil {
+		config.Congestion = def.Congestion
+	}
+
+	return config
+}
@@ -0,0 +1,186 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"time"
+
+	"github.com/beito123/binary"
+)
+
+// CongestionController decides when a session can send datagrams
+type CongestionController interface {
+	// CanSend returns whether a datagram can be sent now
+	// inFlight is the bytes of datagrams waiting for an ACK
+	CanSend(inFlight int, now time.Time) bool
+
+	// OnSent is called when a datagram is sent
+	OnSent(index binary.Triad, size int, now time.Time)
+
+	// OnACK is called when a datagram is acknowledged
+	// continuous is whether it was sent while more packets were waiting
+	OnACK(index binary.Triad, continuous bool)
+
+	// OnLoss is called when a datagram is lost
+	// timeout is whether it's detected by a retransmission timeout instead of a NAK
+	OnLoss(index binary.Triad, timeout bool)
+
+	// OnRTT is called with a rtt sample
+	OnRTT(sample time.Duration)
+
+	// NeedsBAndAS returns whether datagrams ask the remote system to measure
+	// the bandwidth and the arrival speed
+	NeedsBAndAS() bool
+}
+
+// SlidingWindow is a congestion controller based on CCRakNetSlidingWindow of RakNet
+//
+// The congestion window grows by a mtu for each ACK in slow start,
+// and by mtu*mtu/cwnd after it reaches the slow start threshold.
+// It's halved on a NAK and reset to a mtu on a retransmission timeout
+type SlidingWindow struct {
+	mtu      float64
+	cwnd     float64 // congestion window in bytes
+	ssThresh float64 // slow start threshold in bytes, 0 if unset
+
+	lastSent      binary.Triad // last datagram sequence number sent
+	recoveryIndex binary.Triad // losses of datagrams before it are already handled
+}
+
+// NewSlidingWindow returns a new SlidingWindow
+func NewSlidingWindow(mtu int) *SlidingWindow {
+	return &SlidingWindow{
+		mtu:  float64(mtu),
+		cwnd: float64(mtu),
+	}
+}
+
+// IsSlowStart returns whether the window is in slow start
+func (sw *SlidingWindow) IsSlowStart() bool {
+	return sw.ssThresh == 0 || sw.cwnd <= sw.ssThresh
+}
+
+// Window returns the congestion window in bytes
+func (sw *SlidingWindow) Window() int {
+	return int(sw.cwnd)
+}
+
+// CanSend returns whether the bytes in flight are smaller than the window
+func (sw *SlidingWindow) CanSend(inFlight int, now time.Time) bool {
+	return float64(inFlight) < sw.cwnd
+}
+
+// OnSent is called when a datagram is sent
+func (sw *SlidingWindow) OnSent(index binary.Triad, size int, now time.Time) {
+	sw.lastSent = index
+}
+
+// OnACK grows the window
+// The window isn't grown if the sender didn't use it
+func (sw *SlidingWindow) OnACK(index binary.Triad, continuous bool) {
+	if !continuous {
+		return
+	}
+
+	if sw.IsSlowStart() {
+		sw.cwnd += sw.mtu
+		if sw.ssThresh != 0 && sw.cwnd > sw.ssThresh {
+			sw.cwnd = sw.ssThresh + sw.mtu*sw.mtu/sw.cwnd
+		}
+	} else {
+		sw.cwnd += sw.mtu * sw.mtu / sw.cwnd
+	}
+}
+
+// OnLoss shrinks the window once for the datagrams sent before a loss
+func (sw *SlidingWindow) OnLoss(index binary.Triad, timeout bool) {
+	if triadDistance(sw.recoveryIndex, index) < 0 { // sent before the last decrease
+		return
+	}
+
+	sw.recoveryIndex = nextTriad(sw.lastSent)
+
+	sw.ssThresh = sw.cwnd / 2
+	if sw.ssThresh < sw.mtu {
+		sw.ssThresh = sw.mtu
+	}
+
+	if timeout {
+		sw.cwnd = sw.mtu
+	} else {
+		sw.cwnd = sw.ssThresh
+	}
+}
+
+// OnRTT does nothing, the window is driven by ACKs and losses
+func (sw *SlidingWindow) OnRTT(sample time.Duration) {
+}
+
+// NeedsBAndAS returns true in slow start like RakNet
+func (sw *SlidingWindow) NeedsBAndAS() bool {
+	return sw.IsSlowStart()
+}
+
+// FixedRate is a congestion controller which sends at a fixed bandwidth
+// It ignores losses, so it's for networks without congestion like LAN
+type FixedRate struct {
+	rate   float64 // bytes per second
+	burst  float64 // maximum bytes sent at once
+	tokens float64
+	last   time.Time
+}
+
+// NewFixedRate returns a new FixedRate
+// rate is bytes per second and burst is the maximum bytes sent at once
+func NewFixedRate(rate int, burst int) *FixedRate {
+	return &FixedRate{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// CanSend returns whether the bandwidth has been left
+func (fr *FixedRate) CanSend(inFlight int, now time.Time) bool {
+	if !fr.last.IsZero() {
+		fr.tokens += fr.rate * now.Sub(fr.last).Seconds()
+		if fr.tokens > fr.burst {
+			fr.tokens = fr.burst
+		}
+	}
+
+	fr.last = now
+
+	return fr.tokens > 0
+}
+
+// OnSent consumes the bandwidth
+func (fr *FixedRate) OnSent(index binary.Triad, size int, now time.Time) {
+	fr.tokens -= float64(size)
+}
+
+// OnACK does nothing
+func (fr *FixedRate) OnACK(index binary.Triad, continuous bool) {
+}
+
+// OnLoss does nothing
+func (fr *FixedRate) OnLoss(index binary.Triad, timeout bool) {
+}
+
+// OnRTT does nothing
+func (fr *FixedRate) OnRTT(sample time.Duration) {
+}
+
+// NeedsBAndAS returns false, the rate doesn't depend on the remote system
+func (fr *FixedRate) NeedsBAndAS() bool {
+	return false
+}
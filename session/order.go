@@ -0,0 +1,169 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"sort"
+
+	"github.com/beito123/binary"
+	"github.com/beito123/raklib/protocol"
+)
+
+const (
+	// MaxOrderChannels is the number of ordering channels
+	MaxOrderChannels = 32
+
+	// MaxOrderWindow is the maximum distance of an out of order packet which is held
+	MaxOrderWindow = 512
+
+	triadMask = 1<<24 - 1
+)
+
+// nextTriad returns the index after index, which wraps around at 24 bits
+func nextTriad(index binary.Triad) binary.Triad {
+	return (index + 1) & triadMask
+}
+
+// triadDistance returns how far index is ahead of base
+// It's negative if index is before base, considering wraparound of 24 bits
+func triadDistance(base, index binary.Triad) int {
+	d := int(index-base) & triadMask
+	if d > triadMask/2 {
+		d -= triadMask + 1
+	}
+
+	return d
+}
+
+// orderChannel is a reorder buffer of an ordering channel
+//
+// Sequenced packets have the order index of the next ordered packet sent after them like RakNet.
+// They're dropped if the ordered packet has been received, held until the ordered packets before it
+// are received, and the ones older than the newest sequenced packet of the order index are dropped
+type orderChannel struct {
+	next    binary.Triad // next expected order index
+	pending map[binary.Triad]*protocol.EncapsulatedPacket
+
+	sequenced    map[binary.Triad][]*protocol.EncapsulatedPacket // sequenced packets held by order index
+	held         int                                             // number of held sequenced packets
+	sequenceNext binary.Triad                                    // next acceptable sequence index for next
+}
+
+func newOrderChannel() *orderChannel {
+	return &orderChannel{
+		pending:   make(map[binary.Triad]*protocol.EncapsulatedPacket),
+		sequenced: make(map[binary.Triad][]*protocol.EncapsulatedPacket),
+	}
+}
+
+// canReceive returns whether epk can be held
+// Packets which have already been received can be
+func (ch *orderChannel) canReceive(epk *protocol.EncapsulatedPacket) bool {
+	d := triadDistance(ch.next, epk.OrderIndex)
+	if d >= MaxOrderWindow {
+		return false
+	}
+
+	return !epk.Reliability.IsSequenced() || d <= 0 || ch.held < MaxOrderWindow
+}
+
+// receive holds epk until the packets before it are received
+// It returns packets which are ready in order
+func (ch *orderChannel) receive(epk *protocol.EncapsulatedPacket) []*protocol.EncapsulatedPacket {
+	d := triadDistance(ch.next, epk.OrderIndex)
+	if d < 0 || d >= MaxOrderWindow { // duplicated or too far
+		return nil
+	}
+
+	if d > 0 {
+		ch.pending[epk.OrderIndex] = epk
+		return nil
+	}
+
+	packets := []*protocol.EncapsulatedPacket{epk}
+
+	for {
+		ch.next = nextTriad(ch.next)
+		ch.sequenceNext = 0
+
+		// sequenced packets sent before the ordered packet of next
+		packets = append(packets, ch.releaseSequenced()...)
+
+		pk, ok := ch.pending[ch.next]
+		if !ok {
+			break
+		}
+
+		delete(ch.pending, ch.next)
+		packets = append(packets, pk)
+	}
+
+	return packets
+}
+
+// receiveSequenced returns epk if it's newer than the sequenced packets received before
+// It's held if it was sent after an ordered packet which hasn't been received
+func (ch *orderChannel) receiveSequenced(epk *protocol.EncapsulatedPacket) []*protocol.EncapsulatedPacket {
+	d := triadDistance(ch.next, epk.OrderIndex)
+	if d < 0 || d >= MaxOrderWindow { // sent before the last ordered packet or too far
+		return nil
+	}
+
+	if d > 0 {
+		ch.sequenced[epk.OrderIndex] = append(ch.sequenced[epk.OrderIndex], epk)
+		ch.held++
+
+		return nil
+	}
+
+	if !ch.acceptSequence(epk.SequenceIndex) {
+		return nil
+	}
+
+	return []*protocol.EncapsulatedPacket{epk}
+}
+
+// releaseSequenced returns the held sequenced packets of next by the sequence index
+// The stale ones are dropped
+func (ch *orderChannel) releaseSequenced() []*protocol.EncapsulatedPacket {
+	held, ok := ch.sequenced[ch.next]
+	if !ok {
+		return nil
+	}
+
+	delete(ch.sequenced, ch.next)
+	ch.held -= len(held)
+
+	sort.Slice(held, func(i, j int) bool {
+		return triadDistance(held[i].SequenceIndex, held[j].SequenceIndex) > 0
+	})
+
+	var packets []*protocol.EncapsulatedPacket
+	for _, epk := range held {
+		if ch.acceptSequence(epk.SequenceIndex) {
+			packets = append(packets, epk)
+		}
+	}
+
+	return packets
+}
+
+// acceptSequence returns whether index isn't older than the sequenced packets received before
+func (ch *orderChannel) acceptSequence(index binary.Triad) bool {
+	if triadDistance(ch.sequenceNext, index) < 0 { // stale
+		return false
+	}
+
+	ch.sequenceNext = nextTriad(index)
+
+	return true
+}
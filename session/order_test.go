@@ -0,0 +1,269 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/beito123/binary"
+	"github.com/beito123/raklib/protocol"
+)
+
+// orderFrame is a frame received on an ordering channel in a test
+// The body is the name of the frame, which is compared with the delivered ones
+type orderFrame struct {
+	name     string
+	sequence bool
+	order    binary.Triad
+	seq      binary.Triad
+}
+
+// receiveFrames passes frames to ch and returns the names of the delivered ones
+func receiveFrames(ch *orderChannel, frames []orderFrame) []string {
+	var names []string
+	for _, f := range frames {
+		epk := &protocol.EncapsulatedPacket{
+			Reliability:   protocol.ReliableOrdered,
+			OrderIndex:    f.order,
+			SequenceIndex: f.seq,
+			Body:          []byte(f.name),
+		}
+
+		var packets []*protocol.EncapsulatedPacket
+		if f.sequence {
+			epk.Reliability = protocol.ReliableSequenced
+			packets = ch.receiveSequenced(epk)
+		} else {
+			packets = ch.receive(epk)
+		}
+
+		for _, pk := range packets {
+			names = append(names, string(pk.Body))
+		}
+	}
+
+	return names
+}
+
+func TestOrderChannelSequenced(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []orderFrame
+		want   []string
+	}{
+		{
+			name: "newest only",
+			frames: []orderFrame{
+				{name: "s1", sequence: true, seq: 1},
+				{name: "s0", sequence: true, seq: 0},
+				{name: "s2", sequence: true, seq: 2},
+			},
+			want: []string{"s1", "s2"},
+		},
+		{
+			name: "sent before an ordered packet which was received",
+			frames: []orderFrame{
+				{name: "o0", order: 0},
+				{name: "s", sequence: true, order: 0},
+			},
+			want: []string{"o0"},
+		},
+		{
+			name: "sent after an ordered packet which is missing",
+			frames: []orderFrame{
+				{name: "s", sequence: true, order: 1},
+				{name: "o0", order: 0},
+			},
+			want: []string{"o0", "s"},
+		},
+		{
+			name: "held by the sequence index",
+			frames: []orderFrame{
+				{name: "s2", sequence: true, order: 1, seq: 2},
+				{name: "s1", sequence: true, order: 1, seq: 1},
+				{name: "o0", order: 0},
+				{name: "s0", sequence: true, order: 1, seq: 0},
+			},
+			want: []string{"o0", "s1", "s2"},
+		},
+		{
+			name: "sequence reset by an ordered packet",
+			frames: []orderFrame{
+				{name: "s5", sequence: true, seq: 5},
+				{name: "o0", order: 0},
+				{name: "s0", sequence: true, order: 1, seq: 0},
+			},
+			want: []string{"s5", "o0", "s0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := receiveFrames(newOrderChannel(), test.frames)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("delivered %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderChannelReceive(t *testing.T) {
+	tests := []struct {
+		name   string
+		next   binary.Triad
+		frames []orderFrame
+		want   []string
+	}{
+		{
+			name: "in order",
+			frames: []orderFrame{
+				{name: "o0", order: 0},
+				{name: "o1", order: 1},
+			},
+			want: []string{"o0", "o1"},
+		},
+		{
+			name: "reordered",
+			frames: []orderFrame{
+				{name: "o2", order: 2},
+				{name: "o1", order: 1},
+				{name: "o0", order: 0},
+			},
+			want: []string{"o0", "o1", "o2"},
+		},
+		{
+			name: "duplicated",
+			frames: []orderFrame{
+				{name: "o0", order: 0},
+				{name: "o0", order: 0},
+				{name: "o2", order: 2},
+				{name: "o2", order: 2},
+				{name: "o1", order: 1},
+			},
+			want: []string{"o0", "o1", "o2"},
+		},
+		{
+			name: "wraparound",
+			next: 0xfffffe,
+			frames: []orderFrame{
+				{name: "o1", order: 1},
+				{name: "o0", order: 0},
+				{name: "offfffe", order: 0xfffffe},
+				{name: "offffff", order: 0xffffff},
+				{name: "offfffd", order: 0xfffffd},
+			},
+			want: []string{"offfffe", "offffff", "o0", "o1"},
+		},
+		{
+			name: "out of the window",
+			frames: []orderFrame{
+				{name: "far", order: MaxOrderWindow},
+				{name: "last", order: MaxOrderWindow - 1},
+				{name: "o0", order: 0},
+			},
+			want: []string{"o0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := newOrderChannel()
+			ch.next = test.next
+
+			got := receiveFrames(ch, test.frames)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("delivered %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderChannelCanReceive(t *testing.T) {
+	ch := newOrderChannel()
+	ch.next = 0xffffff
+
+	tests := []struct {
+		name        string
+		reliability protocol.Reliability
+		order       binary.Triad
+		want        bool
+	}{
+		{
+			name:        "next",
+			reliability: protocol.ReliableOrdered,
+			order:       0xffffff,
+			want:        true,
+		},
+		{
+			name:        "received",
+			reliability: protocol.ReliableOrdered,
+			order:       0xfffffe,
+			want:        true,
+		},
+		{
+			name:        "last in the window",
+			reliability: protocol.ReliableOrdered,
+			order:       MaxOrderWindow - 2,
+			want:        true,
+		},
+		{
+			name:        "out of the window",
+			reliability: protocol.ReliableOrdered,
+			order:       MaxOrderWindow - 1,
+			want:        false,
+		},
+		{
+			name:        "sequenced",
+			reliability: protocol.ReliableSequenced,
+			order:       1,
+			want:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			epk := &protocol.EncapsulatedPacket{
+				Reliability: test.reliability,
+				OrderIndex:  test.order,
+			}
+
+			if got := ch.canReceive(epk); got != test.want {
+				t.Errorf("canReceive() = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	sequenced := func(order binary.Triad) *protocol.EncapsulatedPacket {
+		return &protocol.EncapsulatedPacket{
+			Reliability: protocol.ReliableSequenced,
+			OrderIndex:  order,
+		}
+	}
+
+	// held sequenced packets are limited, but the ones which are delivered or dropped at once aren't
+	for i := 0; i < MaxOrderWindow; i++ {
+		ch.receiveSequenced(sequenced(1))
+	}
+
+	if ch.canReceive(sequenced(1)) {
+		t.Error("a sequenced packet is held over the limit")
+	}
+
+	if !ch.canReceive(sequenced(0xffffff)) || !ch.canReceive(sequenced(0xfffffe)) {
+		t.Error("a sequenced packet which isn't held is rejected")
+	}
+
+	if !ch.canReceive(&protocol.EncapsulatedPacket{Reliability: protocol.ReliableOrdered, OrderIndex: 1}) {
+		t.Error("an ordered packet is rejected by the held sequenced packets")
+	}
+}
@@ -0,0 +1,131 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import "github.com/beito123/raklib/protocol"
+
+// Ref: http://www.jenkinssoftware.com/raknet/manual/Doxygen/PacketPriority_8h.html
+
+// Priority decides when a packet is sent
+type Priority int
+
+const (
+	// PriorityImmediate is sent without waiting for the next flush
+	// and before packets with the other priorities
+	PriorityImmediate Priority = iota
+
+	// PriorityHigh is sent more often than PriorityMedium
+	PriorityHigh
+
+	// PriorityMedium is sent more often than PriorityLow
+	PriorityMedium
+
+	// PriorityLow is sent when the other queues give a turn
+	PriorityLow
+
+	// MaxPriorities is the number of priorities
+	MaxPriorities
+)
+
+// priorityWeights is the number of packets sent from each queue in a round
+// Immediate packets are always sent first, so they don't have a weight
+var priorityWeights = [MaxPriorities]int{
+	PriorityHigh:   8,
+	PriorityMedium: 4,
+	PriorityLow:    2,
+}
+
+// IsValid returns whether the priority is valid
+func (p Priority) IsValid() bool {
+	return p >= PriorityImmediate && p < MaxPriorities
+}
+
+// sendQueue queues packets by priority
+// Each round, a queue sends up to its weight packets,
+// so packets with a low priority are delayed but aren't starved
+type sendQueue struct {
+	queues  [MaxPriorities][]*protocol.EncapsulatedPacket
+	credits [MaxPriorities]int
+}
+
+// push adds packets to the back of the queue of p
+func (queue *sendQueue) push(p Priority, packets ...*protocol.EncapsulatedPacket) {
+	queue.queues[p] = append(queue.queues[p], packets...)
+}
+
+// pushFront adds packets to the front of the queue of p
+func (queue *sendQueue) pushFront(p Priority, packets ...*protocol.EncapsulatedPacket) {
+	queue.queues[p] = append(packets[:len(packets):len(packets)], queue.queues[p]...)
+}
+
+// len returns the number of queued packets
+func (queue *sendQueue) len() int {
+	n := 0
+	for _, q := range queue.queues {
+		n += len(q)
+	}
+
+	return n
+}
+
+// next returns the priority of the packet sent next
+// It returns false if no packets are queued
+func (queue *sendQueue) next() (Priority, bool) {
+	if len(queue.queues[PriorityImmediate]) > 0 {
+		return PriorityImmediate, true
+	}
+
+	for round := 0; round < 2; round++ {
+		for p := PriorityHigh; p < MaxPriorities; p++ {
+			if len(queue.queues[p]) > 0 && queue.credits[p] > 0 {
+				return p, true
+			}
+		}
+
+		// all queues used their turn, start a new round
+		queue.credits = priorityWeights
+	}
+
+	return 0, false
+}
+
+// peek returns the packet sent next, or nil if no packets are queued
+func (queue *sendQueue) peek() *protocol.EncapsulatedPacket {
+	p, ok := queue.next()
+	if !ok {
+		return nil
+	}
+
+	return queue.queues[p][0]
+}
+
+// pop removes the packet sent next and returns it, or nil if no packets are queued
+func (queue *sendQueue) pop() *protocol.EncapsulatedPacket {
+	p, ok := queue.next()
+	if !ok {
+		return nil
+	}
+
+	epk := queue.queues[p][0]
+	queue.queues[p][0] = nil
+	queue.queues[p] = queue.queues[p][1:]
+
+	if len(queue.queues[p]) == 0 {
+		queue.queues[p] = nil
+	}
+
+	if p != PriorityImmediate {
+		queue.credits[p]--
+	}
+
+	return epk
+}
@@ -0,0 +1,111 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beito123/raklib/protocol"
+)
+
+// priorityNames are the names of packets queued with each priority in a test
+var priorityNames = [MaxPriorities]string{
+	PriorityImmediate: "I",
+	PriorityHigh:      "H",
+	PriorityMedium:    "M",
+	PriorityLow:       "L",
+}
+
+// pushPackets adds n packets named by the priority to the queue of p
+func pushPackets(queue *sendQueue, p Priority, n int) {
+	for i := 0; i < n; i++ {
+		queue.push(p, &protocol.EncapsulatedPacket{Body: []byte(priorityNames[p])})
+	}
+}
+
+// popPackets pops all packets and returns their names in the order sent
+func popPackets(queue *sendQueue) string {
+	var names []string
+	for queue.len() > 0 {
+		peek := queue.peek()
+
+		epk := queue.pop()
+		if epk != peek {
+			return "peek and pop returned different packets"
+		}
+
+		names = append(names, string(epk.Body))
+	}
+
+	return strings.Join(names, "")
+}
+
+func TestSendQueueWeights(t *testing.T) {
+	queue := &sendQueue{}
+	pushPackets(queue, PriorityLow, 4)
+	pushPackets(queue, PriorityMedium, 8)
+	pushPackets(queue, PriorityHigh, 16)
+	pushPackets(queue, PriorityImmediate, 1)
+
+	want := "I" + strings.Repeat("HHHHHHHHMMMMLL", 2)
+	if got := popPackets(queue); got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+
+	if queue.pop() != nil {
+		t.Error("popped from an empty queue")
+	}
+}
+
+func TestSendQueueNotStarved(t *testing.T) {
+	queue := &sendQueue{}
+	pushPackets(queue, PriorityHigh, 100)
+	pushPackets(queue, PriorityLow, 1)
+
+	got := popPackets(queue)
+	if i := strings.Index(got, "L"); i != priorityWeights[PriorityHigh] {
+		t.Errorf("the low priority packet is sent at %d in %s", i, got)
+	}
+}
+
+func TestSendQueueImmediate(t *testing.T) {
+	queue := &sendQueue{}
+	pushPackets(queue, PriorityHigh, 2)
+	queue.pop()
+
+	// immediate packets don't wait for the turn of the other queues
+	pushPackets(queue, PriorityImmediate, 2)
+
+	if got := popPackets(queue); got != "IIH" {
+		t.Errorf("sent %s, want IIH", got)
+	}
+}
+
+func TestSendQueuePushFront(t *testing.T) {
+	queue := &sendQueue{}
+	newPacket := func(name string) *protocol.EncapsulatedPacket {
+		return &protocol.EncapsulatedPacket{Body: []byte(name)}
+	}
+
+	queue.push(PriorityMedium, newPacket("a"), newPacket("b"))
+
+	front := []*protocol.EncapsulatedPacket{newPacket("c"), newPacket("d")}
+	queue.pushFront(PriorityMedium, front...)
+
+	// the slice passed to pushFront isn't reused by the queue
+	front[1] = newPacket("x")
+
+	if got := popPackets(queue); got != "cdab" {
+		t.Errorf("sent %s, want cdab", got)
+	}
+}
@@ -0,0 +1,81 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+// ReceiptHandler is implemented by handlers which want to know
+// whether packets sent with an ACK receipt are delivered
+type ReceiptHandler interface {
+	// OnReceipt is called when all datagrams carrying the packet are acknowledged,
+	// or when an unreliable packet is lost
+	OnReceipt(session *Session, receipt uint32, delivered bool)
+}
+
+type receiptResult struct {
+	receipt   uint32
+	delivered bool
+}
+
+// acknowledge counts acknowledged packets which need ACK in dg
+func (session *Session) acknowledge(dg *sentDatagram) {
+	for _, epk := range dg.packets {
+		if !epk.NeedACK {
+			continue
+		}
+
+		n, ok := session.receipts[epk.IdentifierACK]
+		if !ok {
+			continue
+		}
+
+		if n > 1 {
+			session.receipts[epk.IdentifierACK] = n - 1
+			continue
+		}
+
+		delete(session.receipts, epk.IdentifierACK)
+		session.receiptResults = append(session.receiptResults, receiptResult{
+			receipt:   epk.IdentifierACK,
+			delivered: true,
+		})
+	}
+}
+
+// lose reports the packet of receipt as lost
+func (session *Session) lose(receipt uint32) {
+	if _, ok := session.receipts[receipt]; !ok {
+		return
+	}
+
+	delete(session.receipts, receipt)
+	session.receiptResults = append(session.receiptResults, receiptResult{
+		receipt:   receipt,
+		delivered: false,
+	})
+}
+
+// dispatchReceipts reports receipt results to the handler
+// It must be called without holding the lock
+func (session *Session) dispatchReceipts() {
+	session.mutex.Lock()
+	results := session.receiptResults
+	session.receiptResults = nil
+	session.mutex.Unlock()
+
+	handler, ok := session.handler.(ReceiptHandler)
+	if !ok {
+		return
+	}
+
+	for _, result := range results {
+		handler.OnReceipt(session, result.receipt, result.delivered)
+	}
+}
@@ -0,0 +1,194 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"time"
+
+	"github.com/beito123/binary"
+	"github.com/beito123/raklib/protocol"
+)
+
+const (
+	// InitialRTO is the retransmission timeout used until the rtt is measured
+	InitialRTO = time.Second
+
+	// MinRTO is the minimum retransmission timeout
+	MinRTO = 50 * time.Millisecond
+
+	// MaxRTO is the maximum retransmission timeout
+	MaxRTO = 2 * time.Second
+
+	// MaxReliableWindow is the maximum distance of a reliable packet received ahead of a missing one
+	MaxReliableWindow = 8192
+)
+
+// sentDatagram is a datagram waiting for an ACK
+type sentDatagram struct {
+	index      binary.Triad
+	packets    []*protocol.EncapsulatedPacket
+	size       int
+	sendTime   time.Time
+	continuous bool // whether it was sent while limited by the congestion window
+}
+
+// resendQueue holds sent datagrams until they're acknowledged
+// The remote system acknowledges every datagram, so unreliable ones are tracked for congestion control too
+//
+// The retransmission timeout is computed from the smoothed rtt and the rtt variance
+// Ref: RFC 6298
+type resendQueue struct {
+	datagrams map[binary.Triad]*sentDatagram
+	inFlight  int // bytes of datagrams waiting for an ACK
+
+	srtt   time.Duration
+	rttVar time.Duration
+	rto    time.Duration
+}
+
+func newResendQueue() *resendQueue {
+	return &resendQueue{
+		datagrams: make(map[binary.Triad]*sentDatagram),
+		rto:       InitialRTO,
+	}
+}
+
+// add adds a sent datagram
+func (queue *resendQueue) add(dg *sentDatagram) {
+	queue.datagrams[dg.index] = dg
+	queue.inFlight += dg.size
+}
+
+// remove removes the datagram of index and returns it
+func (queue *resendQueue) remove(index binary.Triad) *sentDatagram {
+	dg, ok := queue.datagrams[index]
+	if !ok {
+		return nil
+	}
+
+	delete(queue.datagrams, index)
+	queue.inFlight -= dg.size
+
+	return dg
+}
+
+// ack removes the datagram of index and takes a rtt sample
+// It returns nil if the datagram isn't in the queue
+func (queue *resendQueue) ack(index binary.Triad, now time.Time) *sentDatagram {
+	dg := queue.remove(index)
+	if dg == nil {
+		return nil
+	}
+
+	queue.updateRTT(now.Sub(dg.sendTime))
+
+	return dg
+}
+
+// nack removes the datagram of index to resend it
+// It returns nil if the datagram isn't in the queue
+func (queue *resendQueue) nack(index binary.Triad) *sentDatagram {
+	return queue.remove(index)
+}
+
+// expired removes datagrams which have not been acknowledged within the rto
+func (queue *resendQueue) expired(now time.Time) []*sentDatagram {
+	var dgs []*sentDatagram
+	for index, dg := range queue.datagrams {
+		if now.Sub(dg.sendTime) >= queue.rto {
+			dgs = append(dgs, queue.remove(index))
+		}
+	}
+
+	return dgs
+}
+
+// updateRTT updates the smoothed rtt and the rtt variance with a sample
+func (queue *resendQueue) updateRTT(sample time.Duration) {
+	if queue.srtt == 0 {
+		queue.srtt = sample
+		queue.rttVar = sample / 2
+	} else {
+		diff := queue.srtt - sample
+		if diff < 0 {
+			diff = -diff
+		}
+
+		queue.rttVar = (3*queue.rttVar + diff) / 4
+		queue.srtt = (7*queue.srtt + sample) / 8
+	}
+
+	rto := queue.srtt + 4*queue.rttVar
+	if rto < MinRTO {
+		rto = MinRTO
+	} else if rto > MaxRTO {
+		rto = MaxRTO
+	}
+
+	queue.rto = rto
+}
+
+// rtt returns the smoothed rtt
+func (queue *resendQueue) rtt() time.Duration {
+	return queue.srtt
+}
+
+// timeout returns the current retransmission timeout
+func (queue *resendQueue) timeout() time.Duration {
+	return queue.rto
+}
+
+// size returns the number of datagrams waiting for an ACK
+func (queue *resendQueue) size() int {
+	return len(queue.datagrams)
+}
+
+// reliableWindow tracks received reliable indexes to drop duplicated packets
+type reliableWindow struct {
+	start    binary.Triad // all indexes before start have been received
+	received map[binary.Triad]bool
+}
+
+func newReliableWindow() *reliableWindow {
+	return &reliableWindow{
+		received: make(map[binary.Triad]bool),
+	}
+}
+
+// canReceive returns whether index is within the window
+// Indexes which have already been received are within it
+func (window *reliableWindow) canReceive(index binary.Triad) bool {
+	return triadDistance(window.start, index) < MaxReliableWindow
+}
+
+// has returns whether index has already been received
+func (window *reliableWindow) has(index binary.Triad) bool {
+	return triadDistance(window.start, index) < 0 || window.received[index]
+}
+
+// receive marks index as received
+// It returns false if it was already received or it's beyond the window
+func (window *reliableWindow) receive(index binary.Triad) bool {
+	d := triadDistance(window.start, index)
+	if d < 0 || d >= MaxReliableWindow || window.received[index] {
+		return false
+	}
+
+	window.received[index] = true
+
+	for window.received[window.start] {
+		delete(window.received, window.start)
+		window.start = nextTriad(window.start)
+	}
+
+	return true
+}
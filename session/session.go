@@ -0,0 +1,851 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/beito123/binary"
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+)
+
+const (
+	// Timeout is the time until an inactive session is closed
+	Timeout = 10 * time.Second
+
+	// PingInterval is the interval of connected pings which keep an idle session alive
+	PingInterval = 2 * time.Second
+
+	// MaxNACKWindow is the largest gap of datagrams which are requested again
+	MaxNACKWindow = 512
+
+	// MaxPendingMessages is the maximum number of messages held until the connected handshake is finished
+	MaxPendingMessages = 256
+)
+
+// State is the connection state of a session
+type State int
+
+const (
+	// StateConnecting is the state until the client handshake is finished
+	StateConnecting State = iota
+
+	// StateConnected is the state after the client handshake is finished
+	StateConnected
+
+	// StateDisconnected is the state after the session is closed
+	StateDisconnected
+)
+
+// Reasons passed to Handler.OnClose
+const (
+	ReasonTimeout          = "timeout"
+	ReasonClientDisconnect = "client disconnect"
+	ReasonServerDisconnect = "server disconnect"
+	ReasonServerClosed     = "server closed"
+	ReasonClientClosed     = "client closed"
+	ReasonReplaced         = "replaced by a new connection"
+)
+
+// Handler handles events of a session
+type Handler interface {
+	// OnOpen is called when the connected handshake is finished
+	OnOpen(session *Session)
+
+	// OnPacket is called with the payload of each received message
+	OnPacket(session *Session, payload []byte)
+
+	// OnClose is called when an opened session is closed
+	OnClose(session *Session, reason string)
+}
+
+// MessageHandler is implemented by handlers which want user messages decoded
+// Messages registered with Protocol.RegisterMessage are passed to OnMessage,
+// and the other messages are passed to OnPacket
+type MessageHandler interface {
+	OnMessage(session *Session, pk raklib.Packet)
+}
+
+// Session is a connection with a remote system
+type Session struct {
+	conn    net.PacketConn
+	udpAddr net.Addr
+	addr    raklib.SystemAddress
+	mtu     uint16
+	guid    int64
+	handler Handler
+
+	mutex      sync.Mutex
+	state      State
+	client     bool // whether the local system is the client
+	lastUpdate time.Time
+	lastPing   time.Time
+
+	sendIndex       binary.Triad // next datagram sequence number
+	reliableIndex   binary.Triad // next reliable index
+	splitID         uint16       // next split id
+	sendQueue       sendQueue
+	congestion      CongestionController
+	receipts        map[uint32]int // receipt id to the number of unacknowledged packets
+	receiptResults  []receiptResult
+	orderIndexes    [MaxOrderChannels]binary.Triad
+	sequenceIndexes [MaxOrderChannels]binary.Triad
+	resendQueue     *resendQueue
+
+	receiveIndex   binary.Triad // next expected datagram sequence number
+	ackQueue       []binary.Triad
+	nackQueue      []binary.Triad
+	reliableWindow *reliableWindow
+	orderChannels  [MaxOrderChannels]*orderChannel
+	splitTable     *splitTable
+	pending        [][]byte // messages received before the connected handshake is finished
+
+	protocol           *protocol.Protocol
+	systemAddressCount int
+}
+
+// NewSession returns a new Session
+// Zero fields of config are set to the default values
+func NewSession(conn net.PacketConn, udpAddr net.Addr, addr raklib.SystemAddress, mtu uint16, guid int64, handler Handler, config Config) *Session {
+	config = config.WithDefaults()
+
+	session := &Session{
+		conn:           conn,
+		udpAddr:        udpAddr,
+		addr:           addr,
+		mtu:            mtu,
+		guid:           guid,
+		handler:        handler,
+		state:          StateConnecting,
+		lastUpdate:     time.Now(),
+		resendQueue:    newResendQueue(),
+		reliableWindow: newReliableWindow(),
+		splitTable:     newSplitTable(config),
+		receipts:       make(map[uint32]int),
+		congestion:     config.Congestion(int(mtu) - raklib.UDPHeaderSize),
+
+		protocol:           config.Protocol,
+		systemAddressCount: config.SystemAddressCount,
+	}
+
+	for i := range session.orderChannels {
+		session.orderChannels[i] = newOrderChannel()
+	}
+
+	return session
+}
+
+// Connect starts the connected handshake as the client
+// guid is the guid of the local system
+// The handler's OnOpen is called when the server accepts the connection
+func (session *Session) Connect(guid int64) error {
+	session.mutex.Lock()
+	if session.state != StateConnecting || session.client {
+		session.mutex.Unlock()
+		return errors.New("the session is already connecting")
+	}
+
+	session.client = true
+	session.mutex.Unlock()
+
+	return session.sendPacket(&protocol.ClientConnectDataPacket{
+		UUID: guid,
+		Time: timestamp(),
+	}, protocol.Reliable, PriorityImmediate, 0)
+}
+
+// Address returns the address of the remote system
+func (session *Session) Address() raklib.SystemAddress {
+	return session.addr
+}
+
+// MTU returns the mtu size agreed on with the remote system
+func (session *Session) MTU() uint16 {
+	return session.mtu
+}
+
+// GUID returns the guid of the remote system
+func (session *Session) GUID() int64 {
+	return session.guid
+}
+
+// State returns the connection state
+func (session *Session) State() State {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.state
+}
+
+// RTT returns the smoothed round trip time
+func (session *Session) RTT() time.Duration {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.resendQueue.rtt()
+}
+
+// HandleDatagram handles a connected datagram received from the remote system
+func (session *Session) HandleDatagram(b []byte) error {
+	dg, err := protocol.DecodeDatagram(b)
+	if err != nil {
+		return err
+	}
+
+	// any valid datagram shows the remote system is alive
+	session.mutex.Lock()
+	session.lastUpdate = time.Now()
+	session.mutex.Unlock()
+
+	switch pk := dg.(type) {
+	case *protocol.ACKPacket:
+		session.handleACK(pk.Packets)
+	case *protocol.NACKPacket:
+		session.handleNACK(pk.Packets)
+	case *protocol.DataPacket:
+		return session.handleDataPacket(pk)
+	}
+
+	return nil
+}
+
+func (session *Session) handleDataPacket(pk *protocol.DataPacket) error {
+	var messages [][]byte
+
+	session.mutex.Lock()
+
+	// The datagram isn't acknowledged if it can't be held now,
+	// so the remote system sends it again later
+	if !session.canReceive(pk.Packets) {
+		session.mutex.Unlock()
+		return nil
+	}
+
+	session.receiveDatagram(pk.Index)
+
+	for _, epk := range pk.Packets {
+		for _, rpk := range session.receivePacket(epk) {
+			messages = append(messages, rpk.Body)
+		}
+	}
+	session.mutex.Unlock()
+
+	// Every message is handled even if one of them fails,
+	// because they have already been acknowledged
+	var err error
+	for _, msg := range messages {
+		e := session.handlePacket(msg)
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func (session *Session) handleACK(seqs []binary.Triad) {
+	session.mutex.Lock()
+
+	now := time.Now()
+	for _, seq := range seqs {
+		dg := session.resendQueue.ack(seq, now)
+		if dg != nil {
+			session.congestion.OnRTT(now.Sub(dg.sendTime))
+			session.congestion.OnACK(dg.index, dg.continuous)
+			session.acknowledge(dg)
+		}
+	}
+
+	session.mutex.Unlock()
+
+	session.dispatchReceipts()
+}
+
+func (session *Session) handleNACK(seqs []binary.Triad) {
+	session.mutex.Lock()
+
+	for _, seq := range seqs {
+		dg := session.resendQueue.nack(seq)
+		if dg != nil {
+			session.congestion.OnLoss(dg.index, false)
+			session.resend(dg)
+		}
+	}
+
+	session.mutex.Unlock()
+
+	session.dispatchReceipts()
+}
+
+// resend queues reliable packets of a lost datagram again in front of the send queue
+// Unreliable packets which need ACK are reported as lost
+func (session *Session) resend(dg *sentDatagram) {
+	var packets []*protocol.EncapsulatedPacket
+	for _, epk := range dg.packets {
+		if epk.Reliability.IsReliable() {
+			packets = append(packets, epk)
+		} else if epk.NeedACK {
+			session.lose(epk.IdentifierACK)
+		}
+	}
+
+	// resent packets are sent before new packets
+	if len(packets) > 0 {
+		session.sendQueue.pushFront(PriorityImmediate, packets...)
+	}
+}
+
+// receiveDatagram queues an ACK for index and NACKs for skipped datagrams
+func (session *Session) receiveDatagram(index binary.Triad) {
+	session.ackQueue = append(session.ackQueue, index)
+
+	d := triadDistance(session.receiveIndex, index)
+	if d < 0 { // resent or duplicated
+		for i, seq := range session.nackQueue {
+			if seq == index {
+				session.nackQueue = append(session.nackQueue[:i], session.nackQueue[i+1:]...)
+				break
+			}
+		}
+
+		return
+	}
+
+	if d <= MaxNACKWindow {
+		for seq := session.receiveIndex; seq != index; seq = nextTriad(seq) {
+			session.nackQueue = append(session.nackQueue, seq)
+		}
+	}
+
+	session.receiveIndex = nextTriad(index)
+}
+
+// canReceive returns whether all packets of a datagram can be held
+// Reliable packets are never dropped after the datagram is acknowledged,
+// so the whole datagram is dropped before if one of them can't be held
+func (session *Session) canReceive(packets []*protocol.EncapsulatedPacket) bool {
+	var splits map[uint16]bool
+
+	// messages which overtake the end of the handshake are held until it
+	if session.state == StateConnecting && len(session.pending)+len(packets) > MaxPendingMessages {
+		return false
+	}
+
+	for _, epk := range packets {
+		if epk.Reliability.IsReliable() {
+			if !session.reliableWindow.canReceive(epk.ReliableIndex) {
+				return false
+			}
+
+			if session.reliableWindow.has(epk.ReliableIndex) { // duplicated, it's dropped
+				continue
+			}
+		}
+
+		if epk.HasSplit {
+			if !session.splitTable.isValid(epk) {
+				return false
+			}
+
+			if splits == nil {
+				splits = make(map[uint16]bool)
+			}
+
+			splits[epk.SplitID] = true
+		}
+
+		if epk.Reliability.IsOrdered() {
+			if int(epk.OrderChannel) >= MaxOrderChannels {
+				return false
+			}
+
+			ch := session.orderChannels[epk.OrderChannel]
+			if !ch.canReceive(epk) {
+				return false
+			}
+		}
+	}
+
+	return session.splitTable.canReceive(splits)
+}
+
+// receivePacket returns packets which are ready to be handled by receiving epk
+// Reliable packets which have already been received are dropped,
+// ordered packets are held until the packets before them are received
+// and sequenced packets are dropped if they're older than the newest one or the last ordered packet
+// Split packets are handled after they're reassembled
+func (session *Session) receivePacket(epk *protocol.EncapsulatedPacket) []*protocol.EncapsulatedPacket {
+	if len(epk.Body) == 0 {
+		return nil
+	}
+
+	if epk.Reliability.IsReliable() && !session.reliableWindow.receive(epk.ReliableIndex) {
+		return nil
+	}
+
+	if epk.HasSplit {
+		epk = session.splitTable.receive(epk, time.Now())
+		if epk == nil {
+			return nil
+		}
+	}
+
+	if epk.Reliability.IsOrdered() {
+		if int(epk.OrderChannel) >= MaxOrderChannels {
+			return nil
+		}
+
+		ch := session.orderChannels[epk.OrderChannel]
+
+		if epk.Reliability.IsSequenced() {
+			return ch.receiveSequenced(epk)
+		}
+
+		return ch.receive(epk)
+	}
+
+	return []*protocol.EncapsulatedPacket{epk}
+}
+
+// Update sends queued acknowledgements, packets and connected pings, resends timed out datagrams
+// and drops incomplete split packets which have timed out
+func (session *Session) Update(now time.Time) {
+	session.ping(now)
+
+	session.mutex.Lock()
+	defer session.dispatchReceipts()
+	defer session.mutex.Unlock()
+
+	for _, dg := range session.resendQueue.expired(now) {
+		session.congestion.OnLoss(dg.index, true)
+		session.resend(dg)
+	}
+
+	session.flush(false)
+
+	if len(session.ackQueue) > 0 {
+		pk := &protocol.ACKPacket{}
+		pk.Packets = session.ackQueue
+
+		session.write(pk)
+		session.ackQueue = nil
+	}
+
+	if len(session.nackQueue) > 0 {
+		pk := &protocol.NACKPacket{}
+		pk.Packets = session.nackQueue
+
+		session.write(pk)
+		session.nackQueue = nil
+	}
+
+	session.splitTable.expire(now)
+}
+
+// ping sends a connected ping if PingInterval has passed since the last one
+// The remote system answers it, so both sides see traffic while the session is idle
+func (session *Session) ping(now time.Time) {
+	session.mutex.Lock()
+	send := session.state == StateConnected && now.Sub(session.lastPing) >= PingInterval
+	if send {
+		session.lastPing = now
+	}
+	session.mutex.Unlock()
+
+	if send {
+		session.sendPacket(&protocol.PingDataPacket{
+			Time: timestamp(),
+		}, protocol.Unreliable, PriorityImmediate, 0)
+	}
+}
+
+func (session *Session) handlePacket(b []byte) error {
+	if b[0] >= protocol.IDUserPacketEnum {
+		return session.handleMessage(b)
+	}
+
+	pk, err := session.protocol.Decode(b)
+	if err == protocol.ErrUnknownPacket {
+		return session.handleMessage(b)
+	} else if err != nil {
+		return err
+	}
+
+	switch pk := pk.(type) {
+	case *protocol.PingDataPacket:
+		return session.sendPacket(&protocol.PongDataPacket{
+			PingTime: pk.Time,
+			PongTime: timestamp(),
+		}, protocol.Unreliable, PriorityImmediate, 0)
+	case *protocol.PongDataPacket:
+		// it only keeps the session alive
+	case *protocol.ClientConnectDataPacket:
+		if session.State() != StateConnecting || session.isClient() {
+			return nil
+		}
+
+		reply := &protocol.ServerHandshakeDataPacket{
+			ClientAddr:      session.addr,
+			SystemAddresses: session.systemAddresses(),
+			RequestTime:     pk.Time,
+			Time:            timestamp(),
+		}
+
+		return session.sendPacket(reply, protocol.Reliable, PriorityImmediate, 0)
+	case *protocol.ServerHandshakeDataPacket:
+		if session.State() != StateConnecting || !session.isClient() {
+			return nil
+		}
+
+		reply := &protocol.ClientHandshakeDataPacket{
+			ServerAddr:      session.addr,
+			SystemAddresses: session.systemAddresses(),
+			RequestTime:     pk.Time,
+			Time:            timestamp(),
+		}
+
+		err = session.sendPacket(reply, protocol.ReliableOrdered, PriorityImmediate, 0)
+		if err != nil {
+			return err
+		}
+
+		session.open()
+	case *protocol.ClientHandshakeDataPacket:
+		if session.isClient() {
+			return nil
+		}
+
+		session.open()
+	case *protocol.ClientDisconnectDataPacket:
+		if session.isClient() {
+			session.close(ReasonServerDisconnect, false)
+		} else {
+			session.close(ReasonClientDisconnect, false)
+		}
+	default:
+		return session.handleMessage(b)
+	}
+
+	return nil
+}
+
+// handleMessage passes a message to the handler
+// User messages registered in the protocol are decoded if the handler implements MessageHandler
+// Messages received before the connected handshake is finished are held until it
+func (session *Session) handleMessage(b []byte) error {
+	session.mutex.Lock()
+	state := session.state
+	if state == StateConnecting {
+		session.pending = append(session.pending, b)
+	}
+	session.mutex.Unlock()
+
+	if state != StateConnected {
+		return nil
+	}
+
+	if handler, ok := session.handler.(MessageHandler); ok {
+		pk, err := session.protocol.DecodeMessage(b)
+		if err == nil {
+			handler.OnMessage(session, pk)
+			return nil
+		} else if err != protocol.ErrUnknownPacket {
+			return err
+		}
+	}
+
+	session.handler.OnPacket(session, b)
+
+	return nil
+}
+
+// open finishes the connected handshake and notifies the handler
+// Messages held during the handshake are handled after it
+func (session *Session) open() {
+	session.mutex.Lock()
+	if session.state != StateConnecting {
+		session.mutex.Unlock()
+		return
+	}
+
+	session.state = StateConnected
+	pending := session.pending
+	session.pending = nil
+	session.mutex.Unlock()
+
+	session.handler.OnOpen(session)
+
+	for _, b := range pending {
+		session.handleMessage(b)
+	}
+}
+
+// systemAddresses returns the internal addresses of the local system sent in the connected handshake
+// They aren't used, so unspecified addresses are sent
+func (session *Session) systemAddresses() []raklib.SystemAddress {
+	addrs := make([]raklib.SystemAddress, session.systemAddressCount)
+	for i := range addrs {
+		addrs[i] = raklib.NewSystemAddress(netip.IPv4Unspecified(), 0)
+	}
+
+	return addrs
+}
+
+// isClient returns whether the local system is the client
+func (session *Session) isClient() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.client
+}
+
+// packet is a packet which has a buffer
+type packet interface {
+	raklib.Packet
+	Bytes() []byte
+}
+
+// Send sends payload to the remote system
+// Packets are batched and sent on the next flush by priority,
+// except PriorityImmediate packets which are sent right away
+func (session *Session) Send(payload []byte, reliability protocol.Reliability, priority Priority, orderChannel byte) error {
+	return session.send(payload, reliability, priority, orderChannel, 0)
+}
+
+// SendWithReceipt sends payload with an ACK receipt
+// Whether it's delivered is reported to the handler if it implements ReceiptHandler
+func (session *Session) SendWithReceipt(payload []byte, reliability protocol.Reliability, priority Priority, orderChannel byte, receipt uint32) error {
+	if !reliability.IsNeededACK() {
+		return errors.New("the reliability doesn't have an ACK receipt")
+	}
+
+	return session.send(payload, reliability, priority, orderChannel, receipt)
+}
+
+// sendPacket encodes pk and sends it
+func (session *Session) sendPacket(pk packet, reliability protocol.Reliability, priority Priority, orderChannel byte) error {
+	err := pk.Encode()
+	if err != nil {
+		return err
+	}
+
+	return session.send(pk.Bytes(), reliability, priority, orderChannel, 0)
+}
+
+// send splits payload if needed and adds it to the send queue of priority
+func (session *Session) send(payload []byte, reliability protocol.Reliability, priority Priority, orderChannel byte, receipt uint32) error {
+	if int(orderChannel) >= MaxOrderChannels {
+		return errors.New("invalid order channel")
+	}
+
+	if !priority.IsValid() {
+		return errors.New("invalid priority")
+	}
+
+	if len(payload) == 0 {
+		return protocol.ErrInvalidLength
+	}
+
+	// ACK receipts are only used by the sender like RakNet,
+	// so packets are sent with the reliability without ACK receipt
+	epk := &protocol.EncapsulatedPacket{
+		Reliability:   reliability.WithoutACKReceipt(),
+		OrderChannel:  orderChannel,
+		NeedACK:       reliability.IsNeededACK(),
+		IdentifierACK: receipt,
+		Body:          payload,
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	// The packet is split before the indexes are assigned,
+	// so a packet which can't be sent doesn't leave a gap in them
+	frags, err := session.split(epk)
+	if err != nil {
+		return err
+	}
+
+	var orderIndex, sequenceIndex binary.Triad
+	if reliability.IsSequenced() {
+		orderIndex = session.orderIndexes[orderChannel]
+		sequenceIndex = session.sequenceIndexes[orderChannel]
+		session.sequenceIndexes[orderChannel] = nextTriad(sequenceIndex)
+	} else if reliability.IsOrdered() {
+		orderIndex = session.orderIndexes[orderChannel]
+		session.orderIndexes[orderChannel] = nextTriad(orderIndex)
+		session.sequenceIndexes[orderChannel] = 0
+	}
+
+	for _, frag := range frags {
+		frag.OrderIndex = orderIndex
+		frag.SequenceIndex = sequenceIndex
+
+		if frag.Reliability.IsReliable() {
+			frag.ReliableIndex = session.reliableIndex
+			session.reliableIndex = nextTriad(session.reliableIndex)
+		}
+
+		if frag.NeedACK {
+			session.receipts[receipt]++
+		}
+
+		session.sendQueue.push(priority, frag)
+	}
+
+	if priority == PriorityImmediate {
+		return session.flush(false)
+	}
+
+	return nil
+}
+
+// flush sends the send queue in batched datagrams by priority while the congestion window allows
+// If force is true, the whole queue is sent regardless of the congestion window
+func (session *Session) flush(force bool) error {
+	maxSize := session.maxPacketSize()
+
+	for session.sendQueue.len() > 0 {
+		if !force && !session.congestion.CanSend(session.resendQueue.inFlight, time.Now()) {
+			break
+		}
+
+		var packets []*protocol.EncapsulatedPacket
+		size := 0
+		for epk := session.sendQueue.peek(); epk != nil; epk = session.sendQueue.peek() {
+			if len(packets) > 0 && size+epk.Len() > maxSize {
+				break
+			}
+
+			packets = append(packets, session.sendQueue.pop())
+			size += epk.Len()
+		}
+
+		err := session.sendDatagram(packets, session.sendQueue.len() > 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendDatagram sends packets in a new datagram
+// The datagram is kept until it's acknowledged, so every datagram counts toward the congestion window
+// Only reliable packets of it are resent if it's lost
+// continuous is whether more packets are waiting to be sent
+func (session *Session) sendDatagram(packets []*protocol.EncapsulatedPacket, continuous bool) error {
+	dpk := &protocol.DataPacket{
+		Index:   session.sendIndex,
+		Packets: packets,
+	}
+	session.sendIndex = nextTriad(session.sendIndex)
+
+	if continuous {
+		dpk.Flags |= protocol.FlagContinuousSend
+	}
+
+	if session.congestion.NeedsBAndAS() {
+		dpk.Flags |= protocol.FlagNeedsBAndAS
+	}
+
+	err := dpk.Encode()
+	if err != nil {
+		return err
+	}
+
+	session.congestion.OnSent(dpk.Index, dpk.Buffer.Len(), time.Now())
+
+	session.resendQueue.add(&sentDatagram{
+		index:      dpk.Index,
+		packets:    packets,
+		size:       dpk.Buffer.Len(),
+		sendTime:   time.Now(),
+		continuous: continuous,
+	})
+
+	_, err = session.conn.WriteTo(dpk.Bytes(), session.udpAddr)
+
+	return err
+}
+
+// write encodes pk and writes it to the remote system
+func (session *Session) write(pk packet) error {
+	err := pk.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = session.conn.WriteTo(pk.Bytes(), session.udpAddr)
+
+	return err
+}
+
+// IsTimedOut returns whether the session has been inactive for too long
+func (session *Session) IsTimedOut(now time.Time) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return now.Sub(session.lastUpdate) > Timeout
+}
+
+// Close sends a disconnection notification and closes the session
+func (session *Session) Close(reason string) {
+	session.close(reason, true)
+}
+
+// Abort closes the session without a disconnection notification
+// It's used when the remote system doesn't know the session anymore
+func (session *Session) Abort(reason string) {
+	session.close(reason, false)
+}
+
+func (session *Session) close(reason string, notify bool) {
+	session.mutex.Lock()
+	if session.state == StateDisconnected {
+		session.mutex.Unlock()
+		return
+	}
+
+	opened := session.state == StateConnected
+	session.state = StateDisconnected
+	session.mutex.Unlock()
+
+	if notify {
+		session.sendPacket(&protocol.ClientDisconnectDataPacket{
+			Time: timestamp(),
+		}, protocol.Unreliable, PriorityImmediate, 0)
+
+		session.mutex.Lock()
+		session.flush(true)
+		session.mutex.Unlock()
+	}
+
+	if opened {
+		session.handler.OnClose(session, reason)
+	}
+}
+
+// IsClosed returns whether the session is closed
+func (session *Session) IsClosed() bool {
+	return session.State() == StateDisconnected
+}
+
+// timestamp returns the current time in milliseconds
+func timestamp() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
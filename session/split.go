@@ -0,0 +1,200 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beito123/raklib"
+	"github.com/beito123/raklib/protocol"
+)
+
+// splitPacket is a split packet being reassembled
+type splitPacket struct {
+	fragments [][]byte
+	received  int
+	first     *protocol.EncapsulatedPacket
+	createdAt time.Time
+}
+
+// splitTable reassembles split packets keyed by the split id
+type splitTable struct {
+	maxCount   int
+	maxPackets int
+	timeout    time.Duration
+
+	packets map[uint16]*splitPacket
+}
+
+func newSplitTable(config Config) *splitTable {
+	return &splitTable{
+		maxCount:   config.MaxSplitCount,
+		maxPackets: config.MaxSplitPackets,
+		timeout:    config.SplitTimeout,
+		packets:    make(map[uint16]*splitPacket),
+	}
+}
+
+// isValid returns whether the split count and the split index of a fragment are valid
+func (table *splitTable) isValid(epk *protocol.EncapsulatedPacket) bool {
+	count := int(epk.SplitCount)
+	index := int(epk.SplitIndex)
+
+	if count <= 0 || count > table.maxCount || index < 0 || index >= count {
+		return false
+	}
+
+	spk, ok := table.packets[epk.SplitID]
+
+	return !ok || len(spk.fragments) == count
+}
+
+// canReceive returns whether fragments of the split ids can be held
+// without exceeding the number of split packets reassembled at once
+func (table *splitTable) canReceive(ids map[uint16]bool) bool {
+	n := len(table.packets)
+	for id := range ids {
+		if _, ok := table.packets[id]; !ok {
+			n++
+		}
+	}
+
+	return n <= table.maxPackets
+}
+
+// receive adds a fragment and returns the reassembled packet when all fragments are received
+// It returns nil if the packet is incomplete or the fragment is invalid
+func (table *splitTable) receive(epk *protocol.EncapsulatedPacket, now time.Time) *protocol.EncapsulatedPacket {
+	if !table.isValid(epk) {
+		return nil
+	}
+
+	count := int(epk.SplitCount)
+	index := int(epk.SplitIndex)
+
+	spk, ok := table.packets[epk.SplitID]
+	if !ok {
+		if len(table.packets) >= table.maxPackets {
+			return nil
+		}
+
+		spk = &splitPacket{
+			fragments: make([][]byte, count),
+			first:     epk,
+			createdAt: now,
+		}
+
+		table.packets[epk.SplitID] = spk
+	}
+
+	if len(spk.fragments) != count || spk.fragments[index] != nil {
+		return nil
+	}
+
+	spk.fragments[index] = epk.Body
+	spk.received++
+
+	if spk.received < count {
+		return nil
+	}
+
+	delete(table.packets, epk.SplitID)
+
+	size := 0
+	for _, frag := range spk.fragments {
+		size += len(frag)
+	}
+
+	body := make([]byte, 0, size)
+	for _, frag := range spk.fragments {
+		body = append(body, frag...)
+	}
+
+	return &protocol.EncapsulatedPacket{
+		Reliability:   spk.first.Reliability,
+		ReliableIndex: spk.first.ReliableIndex,
+		SequenceIndex: spk.first.SequenceIndex,
+		OrderIndex:    spk.first.OrderIndex,
+		OrderChannel:  spk.first.OrderChannel,
+		Body:          body,
+	}
+}
+
+// expire drops incomplete split packets which have timed out
+func (table *splitTable) expire(now time.Time) {
+	for id, spk := range table.packets {
+		if now.Sub(spk.createdAt) > table.timeout {
+			delete(table.packets, id)
+		}
+	}
+}
+
+// maxPacketSize returns the maximum size of packets in a datagram
+func (session *Session) maxPacketSize() int {
+	return int(session.mtu) - raklib.UDPHeaderSize - protocol.DataPacketHeaderSize
+}
+
+// split splits epk into fragments which fit in a datagram
+// Fragments are sent reliably because losing one of them loses the whole packet
+// It returns an error if the mtu can't fit a split header and a byte of the body
+func (session *Session) split(epk *protocol.EncapsulatedPacket) ([]*protocol.EncapsulatedPacket, error) {
+	maxSize := session.maxPacketSize()
+	if epk.Len() <= maxSize {
+		return []*protocol.EncapsulatedPacket{epk}, nil
+	}
+
+	reliability := epk.Reliability
+	switch reliability {
+	case protocol.Unreliable:
+		reliability = protocol.Reliable
+	case protocol.UnreliableSequenced:
+		reliability = protocol.ReliableSequenced
+	}
+
+	header := &protocol.EncapsulatedPacket{
+		Reliability: reliability,
+		HasSplit:    true,
+	}
+
+	fragSize := maxSize - header.Len()
+	if fragSize <= 0 {
+		return nil, errors.New("the mtu is too small to split the packet")
+	}
+
+	count := (len(epk.Body) + fragSize - 1) / fragSize
+
+	id := session.splitID
+	session.splitID++
+
+	frags := make([]*protocol.EncapsulatedPacket, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * fragSize
+		if end > len(epk.Body) {
+			end = len(epk.Body)
+		}
+
+		frags = append(frags, &protocol.EncapsulatedPacket{
+			Reliability:   reliability,
+			OrderChannel:  epk.OrderChannel,
+			HasSplit:      true,
+			SplitCount:    int32(count),
+			SplitID:       id,
+			SplitIndex:    int32(i),
+			NeedACK:       epk.NeedACK,
+			IdentifierACK: epk.IdentifierACK,
+			Body:          epk.Body[i*fragSize : end],
+		})
+	}
+
+	return frags, nil
+}
@@ -0,0 +1,203 @@
+package session
+
+/*
+	Raklib
+
+	Copyright (c) 2018 beito
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+*/
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/beito123/raklib/protocol"
+)
+
+// newTestSplitTable returns a split table which holds 2 packets of up to 4 fragments
+func newTestSplitTable() *splitTable {
+	config := DefaultConfig()
+	config.MaxSplitCount = 4
+	config.MaxSplitPackets = 2
+	config.SplitTimeout = time.Second
+
+	return newSplitTable(config)
+}
+
+// newFragment returns a fragment of the split packet of id, which has a byte of index as the body
+func newFragment(id uint16, count, index int) *protocol.EncapsulatedPacket {
+	return &protocol.EncapsulatedPacket{
+		Reliability: protocol.ReliableOrdered,
+		OrderIndex:  7,
+		HasSplit:    true,
+		SplitCount:  int32(count),
+		SplitID:     id,
+		SplitIndex:  int32(index),
+		Body:        []byte{byte(index)},
+	}
+}
+
+func TestSplitTableReceive(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{
+			name:  "in order",
+			order: []int{0, 1, 2, 3},
+		},
+		{
+			name:  "reversed",
+			order: []int{3, 2, 1, 0},
+		},
+		{
+			name:  "shuffled",
+			order: []int{2, 0, 3, 1},
+		},
+		{
+			name:  "duplicated",
+			order: []int{1, 1, 0, 1, 3, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := newTestSplitTable()
+			now := time.Now()
+
+			var got *protocol.EncapsulatedPacket
+			for i, index := range test.order {
+				pk := table.receive(newFragment(1, 4, index), now)
+				if pk == nil {
+					continue
+				}
+
+				if got != nil || i != len(test.order)-1 {
+					t.Fatalf("reassembled at the fragment %d", i)
+				}
+
+				got = pk
+			}
+
+			if got == nil {
+				t.Fatal("not reassembled")
+			}
+
+			if !bytes.Equal(got.Body, []byte{0, 1, 2, 3}) {
+				t.Errorf("body = % x", got.Body)
+			}
+
+			if got.HasSplit || got.Reliability != protocol.ReliableOrdered || got.OrderIndex != 7 {
+				t.Errorf("reassembled %+v", got)
+			}
+
+			if len(table.packets) != 0 {
+				t.Errorf("%d packets are left", len(table.packets))
+			}
+		})
+	}
+}
+
+func TestSplitTableInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		epk  *protocol.EncapsulatedPacket
+	}{
+		{
+			name: "no fragments",
+			epk:  newFragment(1, 0, 0),
+		},
+		{
+			name: "over MaxSplitCount",
+			epk:  newFragment(1, 5, 0),
+		},
+		{
+			name: "negative index",
+			epk:  newFragment(1, 4, -1),
+		},
+		{
+			name: "index out of the count",
+			epk:  newFragment(1, 4, 4),
+		},
+		{
+			name: "count changed",
+			epk:  newFragment(2, 3, 1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := newTestSplitTable()
+			table.receive(newFragment(2, 2, 0), time.Now())
+
+			if table.isValid(test.epk) {
+				t.Error("isValid() = true")
+			}
+
+			if table.receive(test.epk, time.Now()) != nil {
+				t.Error("reassembled")
+			}
+
+			if len(table.packets) != 1 || table.packets[2].received != 1 {
+				t.Error("the table is changed")
+			}
+		})
+	}
+}
+
+func TestSplitTableMaxPackets(t *testing.T) {
+	table := newTestSplitTable()
+	now := time.Now()
+
+	table.receive(newFragment(1, 2, 0), now)
+	table.receive(newFragment(2, 2, 0), now)
+
+	if table.canReceive(map[uint16]bool{3: true}) {
+		t.Error("canReceive() = true over MaxSplitPackets")
+	}
+
+	if !table.canReceive(map[uint16]bool{1: true, 2: true}) {
+		t.Error("canReceive() = false for the held packets")
+	}
+
+	if table.receive(newFragment(3, 2, 0), now) != nil || len(table.packets) != 2 {
+		t.Error("a packet is held over MaxSplitPackets")
+	}
+
+	// a reassembled packet makes room
+	if table.receive(newFragment(1, 2, 1), now) == nil {
+		t.Fatal("not reassembled")
+	}
+
+	if !table.canReceive(map[uint16]bool{3: true}) {
+		t.Error("canReceive() = false after reassembling")
+	}
+}
+
+func TestSplitTableExpire(t *testing.T) {
+	table := newTestSplitTable()
+	now := time.Now()
+
+	table.receive(newFragment(1, 2, 0), now)
+	table.receive(newFragment(2, 2, 0), now.Add(time.Second))
+
+	table.expire(now.Add(time.Second))
+	if len(table.packets) != 2 {
+		t.Fatalf("%d packets are left before the timeout", len(table.packets))
+	}
+
+	table.expire(now.Add(1500 * time.Millisecond))
+	if _, ok := table.packets[1]; ok || len(table.packets) != 1 {
+		t.Fatal("the timed out packet isn't dropped")
+	}
+
+	// the rest of the dropped packet is held as a new one
+	if table.receive(newFragment(1, 2, 1), now.Add(2*time.Second)) != nil {
+		t.Error("reassembled with the dropped fragment")
+	}
+}